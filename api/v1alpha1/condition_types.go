@@ -0,0 +1,86 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionUnsupported is set when the spec asks for something the server can't do
+	ConditionUnsupported = "Unsupported"
+	// ConditionRenameRefused is set when the User's identity changed but its rename policy refuses renames
+	ConditionRenameRefused = "RenameRefused"
+	// ConditionSecretConflict is set when the credentials secret belongs to something else,
+	// or another User names the same secret
+	ConditionSecretConflict = "SecretConflict"
+	// ConditionSecretTargetRefused is set when credentials can't be copied to some of the secret targets
+	ConditionSecretTargetRefused = "SecretTargetRefused"
+	// ConditionUsernameConflict is set when the username is taken by another User or an existing account
+	ConditionUsernameConflict = "UsernameConflict"
+	// ConditionOwnershipConflict is set when an object on the server is marked as managed by another resource
+	ConditionOwnershipConflict = "OwnershipConflict"
+	// ConditionDrifted is set when objects on the server were changed or dropped outside the
+	// operator and had to be repaired. It describes the most recent repair, and is cleared by the
+	// next resync that finds nothing missing.
+	ConditionDrifted = "Drifted"
+	// ConditionFailed is set when the server refused a statement in a way retrying won't fix.
+	// It is cleared by the next successful reconcile.
+	ConditionFailed = "Failed"
+)
+
+// Condition describes one aspect of the observed state of a resource
+type Condition struct {
+	Type               string                 `json:"type"`
+	Status             metav1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"last_transition_time,omitempty"`
+}
+
+// SetCondition adds or updates the condition of the same type,
+// only bumping the transition time when the status changes.
+func SetCondition(conditions *[]Condition, condition Condition) {
+	for i, existing := range *conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		} else {
+			condition.LastTransitionTime = metav1.Now()
+		}
+
+		(*conditions)[i] = condition
+		return
+	}
+
+	condition.LastTransitionTime = metav1.Now()
+	*conditions = append(*conditions, condition)
+}
+
+// FindCondition returns the condition of the given type, or nil if it isn't set
+func FindCondition(conditions []Condition, conditionType string) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+
+	return nil
+}
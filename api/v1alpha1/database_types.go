@@ -32,13 +32,17 @@ type DatabaseSpec struct {
 	Name      string `json:"name"`
 	Collation string `json:"collation,omitempty"`
 	Encoding  string `json:"encoding,omitempty"`
+	// ResyncInterval overrides the operator's --resync-interval for this database. The schema
+	// is checked on the server this often even if the Database doesn't change; 0 disables it.
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
 }
 
 // DatabaseStatus defines the observed state of Database
 type DatabaseStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	CreatedAt metav1.Time `json:"created_at,omitempty"`
+	CreatedAt  metav1.Time `json:"created_at,omitempty"`
+	Conditions []Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
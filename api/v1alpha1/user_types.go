@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -26,6 +27,12 @@ import (
 type GrantSpec struct {
 	Target     string   `json:"target"`
 	Privileges []string `json:"privileges"`
+	// Exclude lists schemas to carve out of a global `*.*` grant using partial revokes.
+	// It requires `partial_revokes` to be enabled on the server.
+	Exclude []string `json:"exclude,omitempty"`
+	// Pattern sends the schema name in Target as-is, so `_` and `%` act as wildcards.
+	// By default they are escaped and the schema name is matched literally.
+	Pattern bool `json:"pattern,omitempty"`
 }
 
 // UserSpec defines the desired state of User
@@ -37,6 +44,144 @@ type UserSpec struct {
 	Host       string      `json:"host,omitempty"`
 	SecretName string      `json:"secretName,omitempty"`
 	Grants     []GrantSpec `json:"grants,omitempty"`
+	// Hosts lists more hosts to create an account on, next to Host. Every
+	// account shares the same password, secret and grants.
+	Hosts []string `json:"hosts,omitempty"`
+	// RenamePolicy decides what happens when Username or Host change on an existing
+	// User. `Rename` (the default) renames the accounts in place, `Refuse` rejects it.
+	// +kubebuilder:validation:Enum=Rename;Refuse
+	RenamePolicy RenamePolicy `json:"renamePolicy,omitempty"`
+	// Authentication selects how the accounts authenticate
+	Authentication *AuthenticationSpec `json:"authentication,omitempty"`
+	// Limits caps the resources each account may use. Accounts are left as
+	// they are if it's not set.
+	Limits *LimitsSpec `json:"limits,omitempty"`
+	// PasswordPolicy sets password expiry, history and failed login tracking on the accounts
+	PasswordPolicy *PasswordPolicySpec `json:"passwordPolicy,omitempty"`
+	// Locked locks the accounts, so nobody can log in with them
+	Locked bool `json:"locked,omitempty"`
+	// TLS requires the accounts to connect over TLS, optionally with a client
+	// certificate issued by the operator
+	TLS *TLSSpec `json:"tls,omitempty"`
+	// PasswordSecretRef takes the password from a key in an existing secret instead of
+	// generating one. Changes to that secret are applied to the accounts.
+	PasswordSecretRef *v1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+	// PasswordGenerator overrides the operator's policy for generated passwords
+	PasswordGenerator *PasswordGeneratorSpec `json:"passwordGenerator,omitempty"`
+	// SecretPolicy decides what happens when the secret named by SecretName already exists
+	// and isn't this User's. `Fail` (the default) stops before any account is created,
+	// `Adopt` takes ownership of it if nothing else owns it, and `Merge` writes the
+	// credentials into it and leaves its ownership alone.
+	// +kubebuilder:validation:Enum=Fail;Adopt;Merge
+	SecretPolicy SecretPolicy `json:"secretPolicy,omitempty"`
+	// SecretTargets lists more secrets, usually in other namespaces, that are kept as
+	// copies of the credentials secret. A namespace only receives copies if it opts in
+	// with the `db.breeze.sh/accept-credentials-from` annotation.
+	SecretTargets []SecretTarget `json:"secretTargets,omitempty"`
+	// CredentialSink is where the credentials are stored under SecretName. It defaults to
+	// the operator's --credential-sink. SecretPolicy and SecretTargets only apply to `Secret`.
+	// +kubebuilder:validation:Enum=Secret;Vault
+	CredentialSink CredentialSink `json:"credentialSink,omitempty"`
+	// ResyncInterval overrides the operator's --resync-interval for this user. The accounts
+	// are compared against the server this often even if the User doesn't change; 0 disables it.
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+}
+
+type AuthenticationSpec struct {
+	// Plugin is the authentication plugin, such as `mysql_native_password`,
+	// `caching_sha2_password` or `auth_socket`. The server default is used if empty.
+	Plugin string `json:"plugin,omitempty"`
+}
+
+type RenamePolicy string
+
+const (
+	RenamePolicyRename RenamePolicy = "Rename"
+	RenamePolicyRefuse RenamePolicy = "Refuse"
+)
+
+type CredentialSink string
+
+const (
+	CredentialSinkSecret CredentialSink = "Secret"
+	CredentialSinkVault  CredentialSink = "Vault"
+)
+
+// SecretTarget names a copy of the credentials secret
+type SecretTarget struct {
+	Namespace string `json:"namespace"`
+	// Name defaults to SecretName
+	Name string `json:"name,omitempty"`
+}
+
+type SecretPolicy string
+
+const (
+	SecretPolicyFail  SecretPolicy = "Fail"
+	SecretPolicyAdopt SecretPolicy = "Adopt"
+	SecretPolicyMerge SecretPolicy = "Merge"
+)
+
+// LimitsSpec holds per-account resource limits, where 0 means unlimited
+type LimitsSpec struct {
+	MaxUserConnections    int32 `json:"maxUserConnections,omitempty"`
+	MaxQueriesPerHour     int32 `json:"maxQueriesPerHour,omitempty"`
+	MaxUpdatesPerHour     int32 `json:"maxUpdatesPerHour,omitempty"`
+	MaxConnectionsPerHour int32 `json:"maxConnectionsPerHour,omitempty"`
+}
+
+// PasswordPolicySpec holds the password management options for the accounts.
+// Unset options are left as they are on the server.
+type PasswordPolicySpec struct {
+	// ExpireAfterDays expires the password after this many days. 0 uses the
+	// server's default_password_lifetime and a negative value never expires.
+	ExpireAfterDays *int32 `json:"expireAfterDays,omitempty"`
+	// History is the number of previous passwords that can't be reused
+	History *int32 `json:"history,omitempty"`
+	// FailedLoginAttempts locks the account after this many consecutive failed logins
+	FailedLoginAttempts *int32 `json:"failedLoginAttempts,omitempty"`
+	// LockTimeDays is how long the account stays locked after too many failed
+	// logins. A negative value keeps it locked until it's unlocked explicitly.
+	LockTimeDays *int32 `json:"lockTimeDays,omitempty"`
+}
+
+// PasswordGeneratorSpec describes generated passwords. Unset options fall back to the
+// operator's flags, and the server's validate_password settings are always met.
+type PasswordGeneratorSpec struct {
+	// +kubebuilder:validation:Minimum=1
+	Length *int32 `json:"length,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MinLowercase *int32 `json:"minLowercase,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MinUppercase *int32 `json:"minUppercase,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MinDigits *int32 `json:"minDigits,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MinSpecial *int32 `json:"minSpecial,omitempty"`
+	// Exclude lists characters that never appear in generated passwords
+	Exclude *string `json:"exclude,omitempty"`
+}
+
+type TLSRequirement string
+
+const (
+	TLSRequireSSL     TLSRequirement = "SSL"
+	TLSRequireX509    TLSRequirement = "X509"
+	TLSRequireSubject TLSRequirement = "Subject"
+)
+
+type TLSSpec struct {
+	// Require is the `REQUIRE` option on the accounts. With `Subject`, the operator
+	// issues a client certificate signed by the CA in CASecretName, stores it in the
+	// credentials secret and requires both its subject and issuer.
+	// +kubebuilder:validation:Enum=SSL;X509;Subject
+	Require TLSRequirement `json:"require"`
+	// CASecretName names a `kubernetes.io/tls` secret with the CA that signs client certificates
+	CASecretName string `json:"caSecretName,omitempty"`
+	// ValidityDays is how long issued client certificates are valid, 90 days by default
+	ValidityDays int32 `json:"validityDays,omitempty"`
+	// RenewBeforeDays is how long before expiry client certificates are renewed, 30 days by default
+	RenewBeforeDays int32 `json:"renewBeforeDays,omitempty"`
 }
 
 // UserStatus defines the observed state of User
@@ -45,6 +190,34 @@ type UserStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	CreatedAt     metav1.Time `json:"created_at,omitempty"`
 	CurrentGrants []GrantSpec `json:"current_grants,omitempty"`
+	Conditions    []Condition `json:"conditions,omitempty"`
+	// Username, Host and Hosts are the identity the accounts were last applied with
+	Username string   `json:"username,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+	// PendingUsername is the username accounts are being created or renamed to. Accounts
+	// found with it belong to this User, left by an attempt that didn't finish.
+	PendingUsername string `json:"pending_username,omitempty"`
+	// AuthenticationPlugin is the plugin the accounts were last identified with
+	AuthenticationPlugin string `json:"authentication_plugin,omitempty"`
+	// Limits are the effective resource limits, as read back from `mysql.user`
+	Limits *LimitsSpec `json:"limits,omitempty"`
+	// PasswordPolicy is the password policy that was last applied
+	PasswordPolicy *PasswordPolicySpec `json:"password_policy,omitempty"`
+	// Locked, PasswordExpired and PasswordExpiresAt are read back from `mysql.user`
+	Locked            bool         `json:"locked,omitempty"`
+	PasswordExpired   bool         `json:"password_expired,omitempty"`
+	PasswordExpiresAt *metav1.Time `json:"password_expires_at,omitempty"`
+	// TLSRequire is the `REQUIRE` option that was last applied to the accounts
+	TLSRequire string `json:"tls_require,omitempty"`
+	// CertificateExpiresAt is when the issued client certificate expires
+	CertificateExpiresAt *metav1.Time `json:"certificate_expires_at,omitempty"`
+	// PasswordHash is the keyed hash of the password the accounts were last identified with
+	PasswordHash string `json:"password_hash,omitempty"`
+	// PasswordChangedAt is when the accounts were last identified with a different password
+	PasswordChangedAt *metav1.Time `json:"password_changed_at,omitempty"`
+	// CredentialSink is where the credentials were stored
+	CredentialSink CredentialSink `json:"credential_sink,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,68 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var userlog = logf.Log.WithName("user-resource")
+
+func (r *User) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-db-breeze-sh-v1alpha1-user,mutating=false,failurePolicy=fail,groups=db.breeze.sh,resources=users,versions=v1alpha1,name=vuser.kb.io
+
+var _ webhook.Validator = &User{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *User) ValidateCreate() error {
+	return nil
+}
+
+// ValidateUpdate rejects username and host changes on Users that refuse renames
+func (r *User) ValidateUpdate(old runtime.Object) error {
+	oldUser, ok := old.(*User)
+	if !ok {
+		return fmt.Errorf("expected a User but got a %T", old)
+	}
+
+	if r.Spec.RenamePolicy != RenamePolicyRefuse && oldUser.Spec.RenamePolicy != RenamePolicyRefuse {
+		return nil
+	}
+
+	if r.Spec.Username != oldUser.Spec.Username || r.Spec.Host != oldUser.Spec.Host {
+		userlog.Info("refusing rename", "name", r.Name, "namespace", r.Namespace)
+		return fmt.Errorf("user %s/%s has renamePolicy Refuse, username and host can't be changed", r.Namespace, r.Name)
+	}
+
+	return nil
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *User) ValidateDelete() error {
+	return nil
+}
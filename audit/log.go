@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Record describes one statement the operator ran
+type Record struct {
+	Time      time.Time     `json:"time"`
+	Instance  string        `json:"instance"`
+	Subject   *Subject      `json:"subject,omitempty"`
+	Statement string        `json:"statement"`
+	Args      []interface{} `json:"args,omitempty"`
+	Duration  float64       `json:"duration_seconds"`
+	Result    string        `json:"result"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Log writes a record of every statement as a line of JSON
+type Log struct {
+	Writer io.Writer
+	// Recorder, if set, also records statements that change something as events on their subject
+	Recorder record.EventRecorder
+
+	mu sync.Mutex
+}
+
+// Observe records a statement. It is a server.Observer.
+func (l *Log) Observe(ctx context.Context, instance, statement string, args []interface{}, duration time.Duration, err error) {
+	rec := Record{
+		Time:      time.Now().UTC(),
+		Instance:  instance,
+		Subject:   SubjectFrom(ctx),
+		Statement: Redact(statement),
+		Args:      args,
+		Duration:  duration.Seconds(),
+		Result:    "success",
+	}
+
+	if err != nil {
+		rec.Result = "error"
+		rec.Error = redactError(statement, err)
+	}
+
+	l.write(rec)
+
+	if l.Recorder != nil && rec.Subject != nil && !readOnly(statement) {
+		if err != nil {
+			l.Recorder.Eventf(rec.Subject.object, v1.EventTypeWarning, "StatementFailed", "%s on %s: %s", rec.Statement, instance, rec.Error)
+		} else {
+			l.Recorder.Eventf(rec.Subject.object, v1.EventTypeNormal, "StatementExecuted", "%s on %s", rec.Statement, instance)
+		}
+	}
+}
+
+func (l *Log) write(rec Record) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		// arguments that can't be encoded are left out rather than losing the record
+		rec.Args = nil
+		line, _ = json.Marshal(rec)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Writer.Write(append(line, '\n'))
+}
+
+// passwordLiteral matches the string literals statements carry passwords and hashes in:
+// `IDENTIFIED [WITH plugin] BY|AS '...'` and MariaDB's `USING PASSWORD('...')`
+var passwordLiteral = regexp.MustCompile(`(?i)(\b(?:BY|AS)\s+|\bPASSWORD\s*\(\s*)'((?:[^'\\]|\\.|'')*)'`)
+
+// Redact replaces the passwords in a statement
+func Redact(statement string) string {
+	return passwordLiteral.ReplaceAllString(statement, "$1'<redacted>'")
+}
+
+// redactError removes the passwords in a statement from its error, since errors such as
+// syntax errors quote the statement
+func redactError(statement string, err error) string {
+	message := err.Error()
+	for _, match := range passwordLiteral.FindAllStringSubmatch(statement, -1) {
+		if literal := match[2]; literal != "" {
+			message = strings.ReplaceAll(message, literal, "<redacted>")
+		}
+	}
+
+	return message
+}
+
+// readOnlyKeywords start the statements that only read, which aren't recorded as events
+var readOnlyKeywords = map[string]bool{
+	"SELECT":   true,
+	"SHOW":     true,
+	"DESCRIBE": true,
+	"DESC":     true,
+	"EXPLAIN":  true,
+}
+
+func readOnly(statement string) bool {
+	fields := strings.Fields(statement)
+	return len(fields) > 0 && readOnlyKeywords[strings.ToUpper(fields[0])]
+}
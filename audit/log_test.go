@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "CREATE USER 'app'@'%' IDENTIFIED BY '<redacted>'",
+		Redact("CREATE USER 'app'@'%' IDENTIFIED BY 's3cr\\'et'"))
+	assert.Equal(t, "ALTER USER 'app'@'%' IDENTIFIED WITH caching_sha2_password BY '<redacted>' ATTRIBUTE '{}'",
+		Redact("ALTER USER 'app'@'%' IDENTIFIED WITH caching_sha2_password BY 'hunter2' ATTRIBUTE '{}'"))
+	assert.Equal(t, "CREATE USER 'app'@'%' IDENTIFIED VIA ed25519 USING PASSWORD('<redacted>')",
+		Redact("CREATE USER 'app'@'%' IDENTIFIED VIA ed25519 USING PASSWORD('hunter2')"))
+	assert.Equal(t, "GRANT SELECT ON `app`.* TO 'app'@'%'", Redact("GRANT SELECT ON `app`.* TO 'app'@'%'"))
+}
+
+func TestObserve(t *testing.T) {
+	out := &bytes.Buffer{}
+	recorder := record.NewFakeRecorder(10)
+	log := &Log{Writer: out, Recorder: recorder}
+
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app", Generation: 3, ResourceVersion: "42"}}
+	ctx := WithSubject(context.Background(), "User", secret)
+
+	log.Observe(ctx, "db:3306", "SELECT Host FROM mysql.user WHERE User = ?", []interface{}{"app"}, time.Millisecond, nil)
+	log.Observe(ctx, "db:3306", "ALTER USER 'app'@'%' IDENTIFIED BY 'hunter2'", nil, time.Millisecond, errors.New("denied near 'hunter2'"))
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+
+	rec := Record{}
+	assert.NoError(t, json.Unmarshal(lines[1], &rec))
+	assert.Equal(t, "ALTER USER 'app'@'%' IDENTIFIED BY '<redacted>'", rec.Statement)
+	assert.Equal(t, "error", rec.Result)
+	assert.Equal(t, "denied near '<redacted>'", rec.Error)
+	assert.Equal(t, int64(3), rec.Subject.Generation)
+	assert.Equal(t, "42", rec.Subject.ResourceVersion)
+	assert.NotContains(t, out.String(), "hunter2")
+
+	// only the statement that changes something becomes an event
+	assert.Len(t, recorder.Events, 1)
+	assert.NotContains(t, <-recorder.Events, "hunter2")
+}
+
+func TestObserveReadOnly(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	log := &Log{Writer: &bytes.Buffer{}, Recorder: recorder}
+
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app"}}
+	ctx := WithSubject(context.Background(), "User", secret)
+
+	for _, statement := range []string{
+		"SHOW GRANTS FOR 'app'@'%'",
+		"show privileges",
+		"DESCRIBE mysql.user",
+		"EXPLAIN SELECT 1",
+		"select VERSION()",
+	} {
+		log.Observe(ctx, "db:3306", statement, nil, time.Millisecond, nil)
+	}
+
+	assert.Len(t, recorder.Events, 0)
+}
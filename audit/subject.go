@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Object is a resource statements can be run for
+type Object interface {
+	metav1.Object
+	runtime.Object
+}
+
+// Subject is the resource a statement was run for, as it was when its reconcile started
+type Subject struct {
+	Kind            string `json:"kind"`
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	UID             string `json:"uid"`
+	Generation      int64  `json:"generation"`
+	ResourceVersion string `json:"resource_version"`
+
+	object Object
+}
+
+type subjectKey struct{}
+
+// WithSubject returns a context for the statements run while reconciling obj
+func WithSubject(ctx context.Context, kind string, obj Object) context.Context {
+	return context.WithValue(ctx, subjectKey{}, &Subject{
+		Kind:            kind,
+		Namespace:       obj.GetNamespace(),
+		Name:            obj.GetName(),
+		UID:             string(obj.GetUID()),
+		Generation:      obj.GetGeneration(),
+		ResourceVersion: obj.GetResourceVersion(),
+		object:          obj,
+	})
+}
+
+// SubjectFrom returns the subject of the statements run with ctx, or nil if there is none
+func SubjectFrom(ctx context.Context) *Subject {
+	subject, _ := ctx.Value(subjectKey{}).(*Subject)
+	return subject
+}
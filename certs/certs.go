@@ -0,0 +1,162 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// CA signs client certificates for accounts that authenticate with X.509
+type CA struct {
+	Certificate *x509.Certificate
+	// CertificatePEM is the CA certificate as it was loaded, so clients can be given the chain
+	CertificatePEM []byte
+	key            crypto.Signer
+}
+
+// LoadCA parses a PEM encoded CA certificate and its private key, as found in a
+// `kubernetes.io/tls` secret. The key may be PKCS#1, PKCS#8 or SEC 1 encoded.
+func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
+	certificate, err := ParseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	if !certificate.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", OneLine(certificate.Subject))
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM encoded CA key found")
+	}
+
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{Certificate: certificate, CertificatePEM: certPEM, key: key}, nil
+}
+
+// Issue creates a client certificate with the given common name, returning the PEM
+// encoded certificate and PKCS#1 private key.
+func (ca *CA) Issue(commonName string, validity time.Duration) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, key.Public(), ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return certPEM, keyPEM, nil
+}
+
+// ParseCertificate parses the first certificate in PEM encoded data
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("unsupported CA key format")
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported CA key type %T", key)
+	}
+}
+
+// shortNames maps attribute types to the short names OpenSSL uses in one-line names
+var shortNames = map[string]string{
+	"2.5.4.3":              "CN",
+	"2.5.4.5":              "serialNumber",
+	"2.5.4.6":              "C",
+	"2.5.4.7":              "L",
+	"2.5.4.8":              "ST",
+	"2.5.4.9":              "street",
+	"2.5.4.10":             "O",
+	"2.5.4.11":             "OU",
+	"2.5.4.17":             "postalCode",
+	"1.2.840.113549.1.9.1": "emailAddress",
+}
+
+// OneLine renders a distinguished name the way the server compares it in
+// `REQUIRE SUBJECT` and `REQUIRE ISSUER`, e.g. `/C=SE/O=Example/CN=example`.
+// Attributes are kept in the order they're encoded in the certificate.
+func OneLine(name pkix.Name) string {
+	attributes := name.Names
+	if len(attributes) == 0 {
+		for _, rdn := range name.ToRDNSequence() {
+			attributes = append(attributes, rdn...)
+		}
+	}
+
+	var b strings.Builder
+	for _, attribute := range attributes {
+		b.WriteString("/")
+		b.WriteString(shortName(attribute.Type))
+		b.WriteString("=")
+		b.WriteString(fmt.Sprint(attribute.Value))
+	}
+
+	return b.String()
+}
+
+func shortName(oid asn1.ObjectIdentifier) string {
+	if name, ok := shortNames[oid.String()]; ok {
+		return name
+	}
+
+	return oid.String()
+}
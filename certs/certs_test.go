@@ -0,0 +1,72 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func generateCA(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Country: []string{"SE"}, Organization: []string{"Example"}, CommonName: "Example CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestIssue(t *testing.T) {
+	caPEM, caKeyPEM := generateCA(t)
+	ca, err := LoadCA(caPEM, caKeyPEM)
+	assert.NoError(t, err)
+
+	certPEM, keyPEM, err := ca.Issue("example", time.Hour)
+	assert.NoError(t, err)
+	assert.Contains(t, string(keyPEM), "RSA PRIVATE KEY")
+
+	certificate, err := ParseCertificate(certPEM)
+	assert.NoError(t, err)
+	assert.Equal(t, "/CN=example", OneLine(certificate.Subject))
+	assert.Equal(t, "/C=SE/O=Example/CN=Example CA", OneLine(certificate.Issuer))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), certificate.NotAfter, time.Minute)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate)
+	_, err = certificate.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.NoError(t, err)
+}
+
+func TestLoadCARejectsLeafCertificates(t *testing.T) {
+	caPEM, caKeyPEM := generateCA(t)
+	ca, _ := LoadCA(caPEM, caKeyPEM)
+	certPEM, keyPEM, _ := ca.Issue("example", time.Hour)
+
+	_, err := LoadCA(certPEM, keyPEM)
+	assert.Error(t, err)
+}
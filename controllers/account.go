@@ -0,0 +1,828 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/virtualops/sql-operator/grants"
+	"github.com/virtualops/sql-operator/metrics"
+	"github.com/virtualops/sql-operator/ownership"
+	"github.com/virtualops/sql-operator/password"
+	"github.com/virtualops/sql-operator/server"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+)
+
+// account identifies a single 'user'@'host' account on the server
+type account struct {
+	Username string
+	Host     string
+}
+
+// String renders the account as it's written in statements, with both parts quoted
+func (a account) String() string {
+	return sqlString(a.Username) + "@" + sqlString(a.Host)
+}
+
+// passwordlessPlugins authenticate without a password, so they are rendered without one
+var passwordlessPlugins = map[string]bool{
+	"auth_socket": true,
+	"unix_socket": true,
+}
+
+// createAccount creates a single account for the user with the given password
+func (r *UserReconciler) createAccount(ctx context.Context, user *dbv1alpha1.User, a account, password string) error {
+	statement := fmt.Sprintf("CREATE USER %s %s", a, r.identification(user, password))
+	if user.Spec.Limits != nil {
+		statement += " " + resourceOptions(*user.Spec.Limits)
+	}
+
+	if user.Spec.PasswordPolicy != nil {
+		statement += " " + passwordOptions(*user.Spec.PasswordPolicy)
+	}
+
+	if user.Spec.Locked {
+		statement += " " + lockOption(true)
+	}
+
+	if r.Capabilities.Supports(server.FeatureAccountAttributes) {
+		attribute, err := r.ownerMarker(user).Attribute()
+		if err != nil {
+			return err
+		}
+
+		statement += " ATTRIBUTE " + sqlString(attribute)
+	}
+
+	_, err := r.DB.ExecContext(ctx, statement)
+
+	return err
+}
+
+// alterAccount applies account options, such as resource limits or lock state, to an existing account
+func (r *UserReconciler) alterAccount(ctx context.Context, a account, options string) error {
+	_, err := r.DB.ExecContext(ctx, fmt.Sprintf("ALTER USER %s %s", a, options))
+
+	return err
+}
+
+// accountState is what we observe about an account in `mysql.user`
+type accountState struct {
+	Limits          dbv1alpha1.LimitsSpec
+	Locked          bool
+	PasswordExpired bool
+	// PasswordLastChanged and PasswordLifetime are only known on servers supporting account locking
+	PasswordLastChanged *time.Time
+	PasswordLifetime    *int64
+	// Owner is the account's ownership marker, only known on servers supporting account attributes
+	Owner *ownership.Marker
+	// Grants are the privileges the account holds
+	Grants grants.Held
+}
+
+// accountRow is a row of the catalog query in readAccounts. Columns the server doesn't
+// have are left out of the query, and stay zero.
+type accountRow struct {
+	Host                string         `db:"host"`
+	MaxUserConnections  int32          `db:"max_user_connections"`
+	MaxQuestions        int32          `db:"max_questions"`
+	MaxUpdates          int32          `db:"max_updates"`
+	MaxConnections      int32          `db:"max_connections"`
+	AccountLocked       sql.NullString `db:"account_locked"`
+	PasswordExpired     sql.NullString `db:"password_expired"`
+	PasswordLastChanged sql.NullInt64  `db:"password_last_changed"`
+	PasswordLifetime    sql.NullInt64  `db:"password_lifetime"`
+	Attribute           sql.NullString `db:"attribute"`
+	GlobalPrivileges    sql.NullString `db:"global_privileges"`
+	SchemaPrivileges    sql.NullString `db:"schema_privileges"`
+	TablePrivileges     sql.NullString `db:"table_privileges"`
+	UserAttributes      sql.NullString `db:"user_attributes"`
+}
+
+// recordSeparator and fieldSeparator delimit the privileges concatenated into an account
+// row. Being control characters, they don't turn up in names.
+const (
+	recordSeparator = "\x1e"
+	fieldSeparator  = "\x1f"
+)
+
+// privilegeColumns selects the privileges of each account into the catalog query, from the
+// INFORMATION_SCHEMA privilege tables, as records of fields
+var privilegeColumns = func() string {
+	grantee := `CONCAT('''', u.User, '''@''', u.Host, '''')`
+	column := func(fields, table, name string) string {
+		return fmt.Sprintf("(SELECT GROUP_CONCAT(CONCAT_WS('%s', %s) SEPARATOR '%s') FROM INFORMATION_SCHEMA.%s p WHERE p.GRANTEE = %s) AS %s",
+			fieldSeparator, fields, recordSeparator, table, grantee, name)
+	}
+
+	return strings.Join([]string{
+		column("p.PRIVILEGE_TYPE", "USER_PRIVILEGES", "global_privileges"),
+		column("p.TABLE_SCHEMA, p.PRIVILEGE_TYPE", "SCHEMA_PRIVILEGES", "schema_privileges"),
+		column("p.TABLE_SCHEMA, p.TABLE_NAME, p.PRIVILEGE_TYPE", "TABLE_PRIVILEGES", "table_privileges"),
+	}, ", ")
+}()
+
+// parseGrants reads the privileges of an account row. Partial revokes are kept as
+// restrictions in the attributes of mysql.user.
+func parseGrants(row accountRow) (grants.Held, error) {
+	var held grants.Held
+	records := func(column sql.NullString) [][]string {
+		var output [][]string
+		if column.String == "" {
+			return output
+		}
+
+		for _, record := range strings.Split(column.String, recordSeparator) {
+			output = append(output, strings.Split(record, fieldSeparator))
+		}
+
+		return output
+	}
+
+	for _, fields := range records(row.GlobalPrivileges) {
+		held.Grant(grants.CatalogTarget("", ""), fields[0])
+	}
+
+	for _, fields := range records(row.SchemaPrivileges) {
+		if len(fields) == 2 {
+			held.Grant(grants.CatalogTarget(fields[0], ""), fields[1])
+		}
+	}
+
+	for _, fields := range records(row.TablePrivileges) {
+		if len(fields) == 3 {
+			held.Grant(grants.CatalogTarget(fields[0], fields[1]), fields[2])
+		}
+	}
+
+	if row.UserAttributes.String != "" {
+		var attributes struct {
+			Restrictions []struct {
+				Database   string   `json:"Database"`
+				Privileges []string `json:"Privileges"`
+			} `json:"Restrictions"`
+		}
+
+		if err := json.Unmarshal([]byte(row.UserAttributes.String), &attributes); err != nil {
+			return held, err
+		}
+
+		for _, restriction := range attributes.Restrictions {
+			held.Revoke(grants.ExclusionTarget(restriction.Database), restriction.Privileges...)
+		}
+	}
+
+	return held, nil
+}
+
+// readAccounts reads the effective state of every account with the username, including
+// its privileges, in a single catalog query, keyed by host. Accounts that don't exist
+// aren't in the map.
+func (r *UserReconciler) readAccounts(ctx context.Context, username string) (map[string]accountState, error) {
+	columns := "u.Host AS host, u.max_user_connections, u.max_questions, u.max_updates, u.max_connections"
+	from := "mysql.user u"
+
+	// the lock and password expiry columns were added alongside account locking
+	if r.Capabilities.Supports(server.FeatureAccountLock) {
+		columns += ", u.account_locked, u.password_expired, UNIX_TIMESTAMP(u.password_last_changed) AS password_last_changed, u.password_lifetime"
+	}
+
+	if r.Capabilities.Supports(server.FeatureAccountAttributes) {
+		columns += ", a.ATTRIBUTE AS attribute"
+		from += " LEFT JOIN INFORMATION_SCHEMA.USER_ATTRIBUTES a ON a.USER = u.User AND a.HOST = u.Host"
+	}
+
+	// partial revokes aren't in the privilege tables
+	if r.Capabilities.Supports(server.FeaturePartialRevokes) {
+		columns += ", u.User_attributes AS user_attributes"
+	}
+
+	columns += ", " + privilegeColumns
+
+	var rows []accountRow
+	if err := r.DB.SelectContext(ctx, &rows, fmt.Sprintf("SELECT %s FROM %s WHERE u.User = ?", columns, from), username); err != nil {
+		return nil, err
+	}
+
+	accounts := map[string]accountState{}
+	for _, row := range rows {
+		state := accountState{
+			Limits: dbv1alpha1.LimitsSpec{
+				MaxUserConnections:    row.MaxUserConnections,
+				MaxQueriesPerHour:     row.MaxQuestions,
+				MaxUpdatesPerHour:     row.MaxUpdates,
+				MaxConnectionsPerHour: row.MaxConnections,
+			},
+			Locked:          row.AccountLocked.String == "Y",
+			PasswordExpired: row.PasswordExpired.String == "Y",
+		}
+
+		if row.PasswordLastChanged.Valid {
+			changed := time.Unix(row.PasswordLastChanged.Int64, 0)
+			state.PasswordLastChanged = &changed
+		}
+
+		if row.PasswordLifetime.Valid {
+			lifetime := row.PasswordLifetime.Int64
+			state.PasswordLifetime = &lifetime
+		}
+
+		owner, err := ownership.ParseAttribute(row.Attribute.String)
+		if err != nil {
+			return nil, fmt.Errorf("account %s has an unreadable attribute: %w", account{Username: username, Host: row.Host}, err)
+		}
+		state.Owner = owner
+
+		if state.Grants, err = parseGrants(row); err != nil {
+			return nil, fmt.Errorf("account %s has unreadable restrictions: %w", account{Username: username, Host: row.Host}, err)
+		}
+
+		accounts[row.Host] = state
+	}
+
+	return accounts, nil
+}
+
+// resourceOptions renders the `WITH` clause setting every resource limit
+func resourceOptions(limits dbv1alpha1.LimitsSpec) string {
+	return fmt.Sprintf(
+		"WITH MAX_USER_CONNECTIONS %d MAX_QUERIES_PER_HOUR %d MAX_UPDATES_PER_HOUR %d MAX_CONNECTIONS_PER_HOUR %d",
+		limits.MaxUserConnections, limits.MaxQueriesPerHour, limits.MaxUpdatesPerHour, limits.MaxConnectionsPerHour,
+	)
+}
+
+// alterAuthentication switches an existing account to the user's authentication plugin
+func (r *UserReconciler) alterAuthentication(ctx context.Context, user *dbv1alpha1.User, a account, password string) error {
+	_, err := r.DB.ExecContext(ctx, fmt.Sprintf("ALTER USER %s %s", a, r.identification(user, password)))
+
+	return err
+}
+
+// identification renders the clause that sets the account's plugin and password,
+// in the syntax of the server's flavor
+func (r *UserReconciler) identification(user *dbv1alpha1.User, password string) string {
+	plugin := authenticationPlugin(user)
+	mariadb := r.Capabilities.Flavor == server.FlavorMariaDB
+
+	switch {
+	case plugin == "":
+		return fmt.Sprintf("IDENTIFIED BY %s", sqlString(password))
+	case mariadb && passwordlessPlugins[plugin]:
+		return fmt.Sprintf("IDENTIFIED VIA %s", plugin)
+	case mariadb:
+		return fmt.Sprintf("IDENTIFIED VIA %s USING PASSWORD(%s)", plugin, sqlString(password))
+	case passwordlessPlugins[plugin]:
+		return fmt.Sprintf("IDENTIFIED WITH %s", plugin)
+	default:
+		return fmt.Sprintf("IDENTIFIED WITH %s BY %s", plugin, sqlString(password))
+	}
+}
+
+// sqlEscaper escapes string literals for statements that can't take placeholders
+var sqlEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// sqlString renders a quoted string literal
+func sqlString(value string) string {
+	return "'" + sqlEscaper.Replace(value) + "'"
+}
+
+// readAccountState reads the effective state of a single account
+func (r *UserReconciler) readAccountState(ctx context.Context, a account) (accountState, error) {
+	accounts, err := r.readAccounts(ctx, a.Username)
+
+	if err != nil {
+		return accountState{}, err
+	}
+
+	state, ok := accounts[a.Host]
+	if !ok {
+		return state, fmt.Errorf("account %s doesn't exist", a)
+	}
+
+	return state, nil
+}
+
+// ownerMarker identifies the user as the owner of its accounts
+func (r *UserReconciler) ownerMarker(user *dbv1alpha1.User) ownership.Marker {
+	return ownership.Marker{
+		Cluster:   r.ClusterID,
+		Kind:      "User",
+		Namespace: user.Namespace,
+		Name:      user.Name,
+		UID:       string(user.UID),
+	}
+}
+
+// checkOwnership refuses to manage accounts whose marker names another owner, and
+// marks the user's accounts that aren't marked yet or were marked under an old UID
+func (r *UserReconciler) checkOwnership(ctx context.Context, user *dbv1alpha1.User, accounts map[string]accountState) error {
+	if !r.Capabilities.Supports(server.FeatureAccountAttributes) {
+		return nil
+	}
+
+	owner := r.ownerMarker(user)
+
+	for _, a := range appliedAccounts(user) {
+		state, exists := accounts[a.Host]
+		if !exists {
+			continue
+		}
+
+		marker := state.Owner
+
+		if marker != nil && !marker.SameOwner(owner) {
+			return &conflictError{
+				Condition: dbv1alpha1.ConditionOwnershipConflict,
+				Reason:    "AccountOwnedElsewhere",
+				Message:   fmt.Sprintf("account %s is managed by %s", a, marker),
+			}
+		}
+
+		if marker == nil || *marker != owner {
+			attribute, err := owner.Attribute()
+			if err != nil {
+				return err
+			}
+
+			if err := r.alterAccount(ctx, a, "ATTRIBUTE "+sqlString(attribute)); err != nil {
+				return err
+			}
+
+			r.Log.Info("marked account", "account", a.String())
+		}
+	}
+
+	return nil
+}
+
+// healAccounts looks for accounts that were dropped outside the operator. If any are
+// missing, the accounts that are left get a new password, since whoever dropped the
+// others may know it, and it is stored in the credentials. It returns the missing hosts
+// and the new password, or no password if nothing was missing.
+func (r *UserReconciler) healAccounts(ctx context.Context, user *dbv1alpha1.User, hosts []string, accounts map[string]accountState) ([]string, string, error) {
+	var missing []string
+	for _, host := range hosts {
+		if _, ok := accounts[host]; !ok {
+			missing = append(missing, host)
+		}
+	}
+
+	if len(missing) == 0 {
+		clearDrifted(&user.Status.Conditions, fmt.Sprintf("accounts for %s exist on all hosts", appliedUsername(user)))
+		return nil, "", nil
+	}
+
+	password, err := r.initialPassword(ctx, user)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	// a password from the password secret ref is still known, and identifying the accounts
+	// with it again would be refused under a password history policy
+	if r.passwordChanged(user, password) {
+		for _, host := range subtractStrings(hosts, missing) {
+			if err := r.alterAuthentication(ctx, user, account{Username: appliedUsername(user), Host: host}, password); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	if err := r.writeCredentials(ctx, user, map[string]string{secretKeyPassword: password}); err != nil {
+		return nil, "", err
+	}
+
+	r.setPassword(user, password)
+	metrics.DriftDetections.WithLabelValues(r.DB.Instance, "account").Add(float64(len(missing)))
+
+	message := fmt.Sprintf("accounts for %s on hosts %s were dropped outside the operator, recreating them with a new password", appliedUsername(user), strings.Join(missing, ", "))
+	r.Log.Info("accounts drifted", "user", user.Name, "hosts", missing)
+	r.Recorder.Event(user, v1.EventTypeWarning, "AccountsMissing", message)
+	dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+		Type:    dbv1alpha1.ConditionDrifted,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AccountsMissing",
+		Message: message,
+	})
+
+	return missing, password, nil
+}
+
+// dropAccount drops a single account
+func (r *UserReconciler) dropAccount(ctx context.Context, a account) error {
+	_, err := r.DB.ExecContext(ctx, fmt.Sprintf("DROP USER %s%s", r.ifExists(), a))
+
+	return err
+}
+
+// renameAccount renames an account, keeping its password and grants
+func (r *UserReconciler) renameAccount(ctx context.Context, from, to account) error {
+	_, err := r.DB.ExecContext(ctx, fmt.Sprintf("RENAME USER %s TO %s", from, to))
+
+	return err
+}
+
+// applyExecutionPlan runs the grants and revokes of the plan against a single account
+func (r *UserReconciler) applyExecutionPlan(ctx context.Context, a account, executionPlan grants.GrantDiff) error {
+	for _, grant := range executionPlan.Grant {
+		privilegeString := getPrivilegeExpression(grant)
+		_, err := r.DB.ExecContext(ctx, fmt.Sprintf("GRANT %s ON %s TO %s", privilegeString, grants.RenderTarget(grant), a))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, grant := range executionPlan.Revoke {
+		privilegeString := getPrivilegeExpression(grant)
+		_, err := r.DB.ExecContext(ctx, fmt.Sprintf("REVOKE %s ON %s FROM %s", privilegeString, grants.RenderTarget(grant), a))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// partial revokes restrict the global grants above, so they have to go last
+	for _, grant := range executionPlan.PartialRevoke {
+		privilegeString := getPrivilegeExpression(grant)
+		_, err := r.DB.ExecContext(ctx, fmt.Sprintf("REVOKE %s ON %s FROM %s", privilegeString, grants.RenderTarget(grant), a))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keys the credentials are stored under in the credentials secret
+const (
+	secretKeyUsername = "DB_USERNAME"
+	secretKeyPassword = "DB_PASSWORD"
+)
+
+// secretNameField indexes users by the names of the secrets they read
+const secretNameField = ".spec.secretNames"
+
+// readPassword reads the user's password, from the password secret if the user
+// brings its own, or otherwise from its stored credentials
+func (r *UserReconciler) readPassword(ctx context.Context, user *dbv1alpha1.User) (string, error) {
+	if ref := user.Spec.PasswordSecretRef; ref != nil {
+		secret := &v1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: ref.Name}, secret)
+
+		if err != nil {
+			return "", err
+		}
+
+		password, ok := secret.Data[ref.Key]
+		if !ok {
+			return "", fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+		}
+
+		return string(password), nil
+	}
+
+	data, err := r.readCredentials(ctx, user)
+
+	if err != nil {
+		return "", err
+	}
+
+	password, ok := data[secretKeyPassword]
+	if !ok {
+		return "", fmt.Errorf("credentials %s have no key %s", user.Spec.SecretName, secretKeyPassword)
+	}
+
+	return string(password), nil
+}
+
+// initialPassword is the password new accounts are created with, either the
+// user's own or a newly generated one
+func (r *UserReconciler) initialPassword(ctx context.Context, user *dbv1alpha1.User) (string, error) {
+	if user.Spec.PasswordSecretRef != nil {
+		return r.readPassword(ctx, user)
+	}
+
+	return password.Generate(r.passwordGenerator(user))
+}
+
+// passwordGenerator combines the operator's password policy with the user's overrides,
+// raised to whatever the server's validate_password settings require
+func (r *UserReconciler) passwordGenerator(user *dbv1alpha1.User) password.Policy {
+	policy := r.PasswordGenerator
+	if spec := user.Spec.PasswordGenerator; spec != nil {
+		if spec.Length != nil {
+			policy.Length = int(*spec.Length)
+		}
+		if spec.MinLowercase != nil {
+			policy.MinLowercase = int(*spec.MinLowercase)
+		}
+		if spec.MinUppercase != nil {
+			policy.MinUppercase = int(*spec.MinUppercase)
+		}
+		if spec.MinDigits != nil {
+			policy.MinDigits = int(*spec.MinDigits)
+		}
+		if spec.MinSpecial != nil {
+			policy.MinSpecial = int(*spec.MinSpecial)
+		}
+		if spec.Exclude != nil {
+			policy.Exclude = *spec.Exclude
+		}
+	}
+
+	return policy.AtLeast(r.serverPasswordRequirements())
+}
+
+// passwordHashPrefix marks hashes keyed with the operator's PasswordHashKey, as opposed to the
+// plain SHA-256 hashes recorded by earlier versions
+const passwordHashPrefix = "hmac-sha256:"
+
+// hashPassword hashes a password so changes can be detected without storing it on the status.
+// The hash is keyed, so it can't be checked against guessed passwords by those who can read Users.
+func (r *UserReconciler) hashPassword(password string) string {
+	mac := hmac.New(sha256.New, r.PasswordHashKey)
+	mac.Write([]byte(password))
+
+	return passwordHashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// passwordChanged reports whether the accounts were identified with a different password,
+// recognising the unkeyed hashes of earlier versions
+func (r *UserReconciler) passwordChanged(user *dbv1alpha1.User, password string) bool {
+	if !strings.HasPrefix(user.Status.PasswordHash, passwordHashPrefix) {
+		sum := sha256.Sum256([]byte(password))
+		return user.Status.PasswordHash != hex.EncodeToString(sum[:])
+	}
+
+	return !hmac.Equal([]byte(user.Status.PasswordHash), []byte(r.hashPassword(password)))
+}
+
+// setPassword records the hash of the password the accounts were identified with,
+// and when it changed
+func (r *UserReconciler) setPassword(user *dbv1alpha1.User, password string) {
+	if r.passwordChanged(user, password) {
+		changedAt := metav1.Now()
+		user.Status.PasswordChangedAt = &changedAt
+	}
+
+	user.Status.PasswordHash = r.hashPassword(password)
+}
+
+// authenticationPlugin returns the plugin the user asks for, or "" for the server default
+func authenticationPlugin(user *dbv1alpha1.User) string {
+	if user.Spec.Authentication == nil {
+		return ""
+	}
+
+	return user.Spec.Authentication.Plugin
+}
+
+// userHosts returns every host the user should have an account on
+func userHosts(user *dbv1alpha1.User) []string {
+	var hosts []string
+	if user.Spec.Host != "" || len(user.Spec.Hosts) == 0 {
+		hosts = append(hosts, user.Spec.Host)
+	}
+
+	for _, host := range user.Spec.Hosts {
+		if !containsString(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+// appliedHosts returns the hosts accounts were created on. Users created before
+// hosts were tracked on the status only ever had an account on the spec host.
+func appliedHosts(user *dbv1alpha1.User) []string {
+	if len(user.Status.Hosts) > 0 {
+		return user.Status.Hosts
+	}
+
+	if user.Status.CreatedAt.IsZero() {
+		return nil
+	}
+
+	return []string{user.Spec.Host}
+}
+
+// username returns the username the accounts should have. Users without one in
+// their spec get one derived from their namespace and name.
+func (r *UserReconciler) username(user *dbv1alpha1.User) string {
+	if user.Spec.Username != "" {
+		return user.Spec.Username
+	}
+
+	// derived usernames are pinned once applied, so they don't follow changes to the
+	// derivation or to the longest username the server accepts
+	if user.Status.Username != "" {
+		return user.Status.Username
+	}
+
+	return server.DeriveUsername(user.Namespace, user.Name, r.Capabilities.MaxUsernameLength())
+}
+
+// checkUsername makes sure no other User and no existing account has the username
+// before accounts are created with it or renamed to it
+func (r *UserReconciler) checkUsername(ctx context.Context, user *dbv1alpha1.User) error {
+	username := r.username(user)
+	if !user.Status.CreatedAt.IsZero() && appliedUsername(user) == username {
+		return nil
+	}
+
+	users := &dbv1alpha1.UserList{}
+	if err := r.List(ctx, users); err != nil {
+		return err
+	}
+
+	for i := range users.Items {
+		other := &users.Items[i]
+		if other.UID != user.UID && (r.username(other) == username || other.Status.Username == username) {
+			return &conflictError{
+				Condition: dbv1alpha1.ConditionUsernameConflict,
+				Reason:    "DuplicateUsername",
+				Message:   fmt.Sprintf("username %s is also used by user %s/%s", username, other.Namespace, other.Name),
+			}
+		}
+	}
+
+	existing, err := r.readAccounts(ctx, username)
+
+	if err != nil {
+		return err
+	}
+
+	var hosts []string
+	for host, state := range existing {
+		if !r.ownsAccount(user, username, state) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+
+	if len(hosts) > 0 {
+		return &conflictError{
+			Condition: dbv1alpha1.ConditionUsernameConflict,
+			Reason:    "AccountExists",
+			Message:   fmt.Sprintf("accounts named %s already exist on hosts: %s", username, strings.Join(hosts, ", ")),
+		}
+	}
+
+	return nil
+}
+
+// ownsAccount reports whether an existing account with the username is the user's own.
+// Marked accounts are the user's if they're marked with it. Servers without attributes
+// can't mark them, so there accounts left under the username the user claimed before an
+// earlier attempt to create or rename its accounts are taken to be its own.
+func (r *UserReconciler) ownsAccount(user *dbv1alpha1.User, username string, state accountState) bool {
+	if r.Capabilities.Supports(server.FeatureAccountAttributes) {
+		return state.Owner != nil && state.Owner.SameOwner(r.ownerMarker(user))
+	}
+
+	return user.Status.PendingUsername == username
+}
+
+// claimUsername records the username accounts are about to be created or renamed to
+// before any of them are, so an attempt that doesn't finish can be picked up again
+func (r *UserReconciler) claimUsername(ctx context.Context, user *dbv1alpha1.User) error {
+	if user.Status.PendingUsername == r.username(user) {
+		return nil
+	}
+
+	user.Status.PendingUsername = r.username(user)
+
+	return r.Status().Update(ctx, user)
+}
+
+// dropPendingAccounts drops the accounts an unfinished creation left under a username
+// the user no longer has. Accounts someone else created under it since are left alone.
+func (r *UserReconciler) dropPendingAccounts(ctx context.Context, user *dbv1alpha1.User) error {
+	pending := user.Status.PendingUsername
+	if pending == "" || pending == r.username(user) {
+		return nil
+	}
+
+	existing, err := r.readAccounts(ctx, pending)
+
+	if err != nil {
+		return err
+	}
+
+	for host, state := range existing {
+		if !r.ownsAccount(user, pending, state) {
+			r.Log.Info("left account of unfinished creation that isn't the user's", "account", account{Username: pending, Host: host}.String())
+			continue
+		}
+
+		if err := r.dropAccount(ctx, account{Username: pending, Host: host}); err != nil {
+			return err
+		}
+		r.Log.Info("dropped account of unfinished creation", "account", account{Username: pending, Host: host}.String())
+	}
+
+	return nil
+}
+
+// appliedUsername returns the username the accounts were created with,
+// falling back to the spec for users created before it was tracked
+func appliedUsername(user *dbv1alpha1.User) string {
+	if user.Status.Username != "" {
+		return user.Status.Username
+	}
+
+	return user.Spec.Username
+}
+
+// appliedHost returns the primary host the account was created with,
+// falling back to the spec for users created before it was tracked
+func appliedHost(user *dbv1alpha1.User) string {
+	if user.Status.Username != "" {
+		return user.Status.Host
+	}
+
+	return user.Spec.Host
+}
+
+// appliedAccounts returns every account that exists for the user on the server
+func appliedAccounts(user *dbv1alpha1.User) []account {
+	var accounts []account
+	for _, host := range appliedHosts(user) {
+		accounts = append(accounts, account{Username: appliedUsername(user), Host: host})
+	}
+
+	return accounts
+}
+
+// passwordOptions renders the password management options set in the policy
+func passwordOptions(policy dbv1alpha1.PasswordPolicySpec) string {
+	var options []string
+
+	if policy.ExpireAfterDays != nil {
+		switch days := *policy.ExpireAfterDays; {
+		case days == 0:
+			options = append(options, "PASSWORD EXPIRE DEFAULT")
+		case days < 0:
+			options = append(options, "PASSWORD EXPIRE NEVER")
+		default:
+			options = append(options, fmt.Sprintf("PASSWORD EXPIRE INTERVAL %d DAY", days))
+		}
+	}
+
+	if policy.History != nil {
+		options = append(options, fmt.Sprintf("PASSWORD HISTORY %d", *policy.History))
+	}
+
+	if policy.FailedLoginAttempts != nil {
+		options = append(options, fmt.Sprintf("FAILED_LOGIN_ATTEMPTS %d", *policy.FailedLoginAttempts))
+	}
+
+	if policy.LockTimeDays != nil {
+		if *policy.LockTimeDays < 0 {
+			options = append(options, "PASSWORD_LOCK_TIME UNBOUNDED")
+		} else {
+			options = append(options, fmt.Sprintf("PASSWORD_LOCK_TIME %d", *policy.LockTimeDays))
+		}
+	}
+
+	return strings.Join(options, " ")
+}
+
+// lockOption renders the option locking or unlocking the account
+func lockOption(locked bool) string {
+	if locked {
+		return "ACCOUNT LOCK"
+	}
+
+	return "ACCOUNT UNLOCK"
+}
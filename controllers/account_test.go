@@ -0,0 +1,183 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+	"github.com/virtualops/sql-operator/grants"
+)
+
+func TestParseGrants(t *testing.T) {
+	column := func(records ...string) sql.NullString {
+		value := ""
+		for i, record := range records {
+			if i > 0 {
+				value += recordSeparator
+			}
+			value += record
+		}
+
+		return sql.NullString{String: value, Valid: value != ""}
+	}
+
+	held, err := parseGrants(accountRow{
+		GlobalPrivileges: column("USAGE", "SELECT"),
+		SchemaPrivileges: column("my\\_app"+fieldSeparator+"SELECT", "my\\_app"+fieldSeparator+"INSERT"),
+		TablePrivileges:  column("shop" + fieldSeparator + "orders" + fieldSeparator + "UPDATE"),
+		UserAttributes:   sql.NullString{String: `{"Restrictions": [{"Database": "payroll", "Privileges": ["SELECT"]}]}`, Valid: true},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, grants.Held{
+		Privileges: map[string][]string{
+			"*.*":             {"SELECT"},
+			"`my\\_app`.*":    {"SELECT", "INSERT"},
+			"`shop`.`orders`": {"UPDATE"},
+		},
+		Revoked: map[string][]string{"`payroll`.*": {"SELECT"}},
+	}, held)
+
+	held, err = parseGrants(accountRow{})
+	assert.NoError(t, err)
+	assert.Equal(t, grants.Held{}, held)
+
+	_, err = parseGrants(accountRow{UserAttributes: sql.NullString{String: "{", Valid: true}})
+	assert.Error(t, err)
+}
+
+func TestUserHosts(t *testing.T) {
+	for name, test := range map[string]struct {
+		spec     dbv1alpha1.UserSpec
+		expected []string
+	}{
+		"primary host only":       {dbv1alpha1.UserSpec{Host: "%"}, []string{"%"}},
+		"no host is the any host": {dbv1alpha1.UserSpec{}, []string{""}},
+		"primary host first":      {dbv1alpha1.UserSpec{Host: "%", Hosts: []string{"10.0.0.1", "localhost"}}, []string{"%", "10.0.0.1", "localhost"}},
+		"duplicates are dropped":  {dbv1alpha1.UserSpec{Host: "%", Hosts: []string{"localhost", "%", "localhost"}}, []string{"%", "localhost"}},
+		"hosts without a primary": {dbv1alpha1.UserSpec{Hosts: []string{"localhost"}}, []string{"localhost"}},
+	} {
+		assert.Equal(t, test.expected, userHosts(&dbv1alpha1.User{Spec: test.spec}), name)
+	}
+}
+
+func TestAppliedHosts(t *testing.T) {
+	created := metav1.Now()
+
+	for name, test := range map[string]struct {
+		user     dbv1alpha1.User
+		expected []string
+	}{
+		"not created": {dbv1alpha1.User{Spec: dbv1alpha1.UserSpec{Host: "%"}}, nil},
+		"tracked hosts": {dbv1alpha1.User{
+			Spec:   dbv1alpha1.UserSpec{Host: "%", Hosts: []string{"localhost"}},
+			Status: dbv1alpha1.UserStatus{CreatedAt: created, Hosts: []string{"%"}},
+		}, []string{"%"}},
+		"created before hosts were tracked": {dbv1alpha1.User{
+			Spec:   dbv1alpha1.UserSpec{Host: "%", Hosts: []string{"localhost"}},
+			Status: dbv1alpha1.UserStatus{CreatedAt: created},
+		}, []string{"%"}},
+	} {
+		assert.Equal(t, test.expected, appliedHosts(&test.user), name)
+	}
+}
+
+func TestPasswordOptions(t *testing.T) {
+	days := func(n int32) *int32 { return &n }
+
+	for expected, policy := range map[string]dbv1alpha1.PasswordPolicySpec{
+		"":                                        {},
+		"PASSWORD EXPIRE DEFAULT":                 {ExpireAfterDays: days(0)},
+		"PASSWORD EXPIRE NEVER":                   {ExpireAfterDays: days(-1)},
+		"PASSWORD EXPIRE INTERVAL 90 DAY":         {ExpireAfterDays: days(90)},
+		"PASSWORD_LOCK_TIME UNBOUNDED":            {LockTimeDays: days(-1)},
+		"FAILED_LOGIN_ATTEMPTS 3":                 {FailedLoginAttempts: days(3)},
+		"PASSWORD HISTORY 5 PASSWORD_LOCK_TIME 1": {History: days(5), LockTimeDays: days(1)},
+		"PASSWORD EXPIRE INTERVAL 30 DAY PASSWORD HISTORY 5 FAILED_LOGIN_ATTEMPTS 3 PASSWORD_LOCK_TIME 2": {
+			ExpireAfterDays: days(30), History: days(5), FailedLoginAttempts: days(3), LockTimeDays: days(2),
+		},
+	} {
+		assert.Equal(t, expected, passwordOptions(policy))
+	}
+}
+
+func TestLockOption(t *testing.T) {
+	assert.Equal(t, "ACCOUNT LOCK", lockOption(true))
+	assert.Equal(t, "ACCOUNT UNLOCK", lockOption(false))
+}
+
+func TestSQLString(t *testing.T) {
+	for value, expected := range map[string]string{
+		"":             "''",
+		"example":      "'example'",
+		"o'brien":      `'o\'brien'`,
+		`back\slash`:   `'back\\slash'`,
+		`\' OR 1=1 --`: `'\\\' OR 1=1 --'`,
+	} {
+		assert.Equal(t, expected, sqlString(value), value)
+	}
+}
+
+func TestPlanRename(t *testing.T) {
+	created := metav1.Now()
+	user := func(spec dbv1alpha1.UserSpec, hosts ...string) *dbv1alpha1.User {
+		return &dbv1alpha1.User{
+			Spec:   spec,
+			Status: dbv1alpha1.UserStatus{CreatedAt: created, Username: "app", Host: hosts[0], Hosts: hosts},
+		}
+	}
+
+	// the username changes on every account
+	renames, drops, hosts := planRename(user(dbv1alpha1.UserSpec{Username: "api", Host: "%"}, "%", "localhost"), "api")
+	assert.Equal(t, []accountRename{
+		{From: account{Username: "app", Host: "%"}, To: account{Username: "api", Host: "%"}},
+		{From: account{Username: "app", Host: "localhost"}, To: account{Username: "api", Host: "localhost"}},
+	}, renames)
+	assert.Empty(t, drops)
+	assert.Equal(t, []string{"%", "localhost"}, hosts)
+
+	// the primary account moves to a new host
+	renames, drops, hosts = planRename(user(dbv1alpha1.UserSpec{Username: "app", Host: "10.0.0.%"}, "%", "localhost"), "app")
+	assert.Equal(t, []accountRename{
+		{From: account{Username: "app", Host: "%"}, To: account{Username: "app", Host: "10.0.0.%"}},
+		{From: account{Username: "app", Host: "localhost"}, To: account{Username: "app", Host: "localhost"}},
+	}, renames)
+	assert.Empty(t, drops)
+	assert.Equal(t, []string{"10.0.0.%", "localhost"}, hosts)
+
+	// the new primary host already has an account, so the old primary one is dropped
+	renames, drops, hosts = planRename(user(dbv1alpha1.UserSpec{Username: "api", Host: "localhost"}, "%", "localhost"), "api")
+	assert.Equal(t, []accountRename{
+		{From: account{Username: "app", Host: "localhost"}, To: account{Username: "api", Host: "localhost"}},
+	}, renames)
+	assert.Equal(t, []account{{Username: "app", Host: "%"}}, drops)
+	assert.Equal(t, []string{"localhost"}, hosts)
+
+	// or kept where it is, when the spec still has its host
+	renames, drops, hosts = planRename(user(dbv1alpha1.UserSpec{Username: "app", Host: "localhost", Hosts: []string{"%"}}, "%", "localhost"), "app")
+	assert.Equal(t, []accountRename{
+		{From: account{Username: "app", Host: "%"}, To: account{Username: "app", Host: "%"}},
+		{From: account{Username: "app", Host: "localhost"}, To: account{Username: "app", Host: "localhost"}},
+	}, renames)
+	assert.Empty(t, drops)
+	assert.Equal(t, []string{"%", "localhost"}, hosts)
+}
@@ -0,0 +1,45 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+)
+
+// reconcileContext is the context a reconcile runs in. It is cancelled when the manager
+// stops, aborting the statements being run, and after timeout if that isn't 0.
+func reconcileContext(base context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if base == nil {
+		base = context.Background()
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(base)
+	}
+
+	return context.WithTimeout(base, timeout)
+}
+
+// accountLockKey and schemaLockKey name the objects on the server reconciles take turns on
+func accountLockKey(username string) string {
+	return "account/" + username
+}
+
+func schemaLockKey(schema string) string {
+	return "schema/" + schema
+}
@@ -19,17 +19,23 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"github.com/jmoiron/sqlx"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"time"
 
 	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 
 	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+	"github.com/virtualops/sql-operator/audit"
+	"github.com/virtualops/sql-operator/metrics"
+	"github.com/virtualops/sql-operator/ownership"
+	"github.com/virtualops/sql-operator/server"
 )
 
 // DatabaseReconciler reconciles a Database object
@@ -37,14 +43,46 @@ type DatabaseReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
-	DB     *sqlx.DB
+	DB     *server.DB
+	// Schemas records which Database manages each schema
+	Schemas *ownership.Schemas
+	// ClusterID identifies this cluster in the ownership markers on the server
+	ClusterID string
+	Recorder  record.EventRecorder
+	// Resync is how often schemas are checked on the server without a change
+	Resync Resync
+	// Context is cancelled when the manager stops
+	Context context.Context
+	// ReconcileTimeout bounds a single reconcile, 0 leaves it unbounded
+	ReconcileTimeout time.Duration
+	// MaxConcurrentReconciles is how many databases are reconciled at once
+	MaxConcurrentReconciles int
+	// Setup is waited for before reconciling
+	Setup *ServerSetup
 }
 
 // +kubebuilder:rbac:groups=db.breeze.sh,resources=databases,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=db.breeze.sh,resources=databases/status,verbs=get;update;patch
-
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 func (r *DatabaseReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+	ctx, cancel := reconcileContext(r.Context, r.ReconcileTimeout)
+	defer cancel()
+
+	// nothing can be done before the server has been set up
+	if err := r.Setup.Wait(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcile(ctx, req)
+
+	if err != nil {
+		return r.reportFailure(ctx, req, err)
+	}
+
+	return result, nil
+}
+
+func (r *DatabaseReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("database", req.NamespacedName)
 	db := &dbv1alpha1.Database{}
 
@@ -61,6 +99,17 @@ func (r *DatabaseReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	log.Info("got db", "database", db)
 
+	// statements are audited as run for this database
+	ctx = audit.WithSubject(ctx, "Database", db)
+
+	// databases naming the same schema take turns
+	unlock, err := r.DB.Locks.Lock(ctx, schemaLockKey(db.Spec.Name))
+
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer unlock()
+
 	finalizerName := "db.breeze.sh/finalizer"
 
 	if db.ObjectMeta.DeletionTimestamp.IsZero() {
@@ -77,43 +126,178 @@ func (r *DatabaseReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		if containsString(db.ObjectMeta.Finalizers, finalizerName) {
 			// our finalizer is present, so lets handle our external dependency
 
-			if _, err := r.DB.Exec(fmt.Sprintf("DROP DATABASE `%s`", db.Spec.Name)); err != nil {
-				// if DB deletion fails, fail reconciliation
+			owner, err := r.Schemas.Owner(ctx, db.Spec.Name)
+
+			if err != nil {
 				return ctrl.Result{}, err
 			}
 
+			// Only a schema recorded as this database's is dropped. Databases created before
+			// schemas were recorded created theirs; any other schema belongs to someone else,
+			// such as one that already existed when this database tried to create it.
+			ours := owner != nil && owner.SameOwner(r.ownerMarker(db))
+			legacy := owner == nil && !db.Status.CreatedAt.IsZero()
+
+			if !ours && !legacy {
+				log.Info("not dropping schema this database didn't create", "schema", db.Spec.Name, "owner", owner)
+			} else {
+				if _, err := r.DB.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", db.Spec.Name)); err != nil {
+					// if DB deletion fails, fail reconciliation
+					return ctrl.Result{}, err
+				}
+
+				if err := r.Schemas.Forget(ctx, db.Spec.Name); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+
 			// If the deletion succeeded, remove the finalizer so deletion can complete
 			db.ObjectMeta.Finalizers = removeString(db.ObjectMeta.Finalizers, finalizerName)
-			if err := r.Update(context.Background(), db); err != nil {
+			if err := r.Update(ctx, db); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
+
+		return ctrl.Result{}, nil
 	}
 
-	// If the DB has already been created, we do nothing since the object is immutable
+	// A single query tells whether the schema exists and who it's recorded as managed by
+	exists, owner, err := r.Schemas.State(ctx, db.Spec.Name)
+
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if owner != nil && !owner.SameOwner(r.ownerMarker(db)) {
+		log.Info("schema is managed elsewhere", "owner", owner.String())
+		dbv1alpha1.SetCondition(&db.Status.Conditions, dbv1alpha1.Condition{
+			Type:    dbv1alpha1.ConditionOwnershipConflict,
+			Status:  metav1.ConditionTrue,
+			Reason:  "SchemaOwnedElsewhere",
+			Message: fmt.Sprintf("schema %s is managed by %s", db.Spec.Name, owner),
+		})
+
+		return ctrl.Result{}, r.Status().Update(ctx, db)
+	}
+
+	// If the DB has already been created, we only check that it still exists, since the object is immutable
+	drifted := false
 	if !db.Status.CreatedAt.IsZero() {
-		log.Info("DB already exists, won't create")
-		return ctrl.Result{}, nil
+		if exists {
+			// schemas created before they were recorded are marked as this database's now
+			if owner == nil {
+				if err := r.Schemas.Record(ctx, db.Spec.Name, r.ownerMarker(db)); err != nil {
+					return ctrl.Result{}, err
+				}
+				log.Info("marked schema", "schema", db.Spec.Name)
+			}
+
+			if clearDrifted(&db.Status.Conditions, fmt.Sprintf("schema %s exists", db.Spec.Name)) {
+				if err := r.Status().Update(ctx, db); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+
+			log.Info("DB already exists, won't create")
+			return ctrl.Result{RequeueAfter: r.Resync.After(db.Spec.ResyncInterval)}, nil
+		}
+
+		log.Info("DB was dropped outside the operator, recreating")
+		metrics.DriftDetections.WithLabelValues(r.DB.Instance, "schema").Inc()
+		drifted = true
 	}
 
-	_, err = r.DB.Exec(fmt.Sprintf("CREATE DATABASE `%s` DEFAULT CHARACTER SET = `%s` DEFAULT COLLATE = `%s`", db.Spec.Name, db.Spec.Encoding, db.Spec.Collation))
+	// a schema already recorded as ours was created before the status could be updated
+	ifNotExists := ""
+	if owner != nil {
+		ifNotExists = "IF NOT EXISTS "
+	}
+
+	_, err = r.DB.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE %s`%s` DEFAULT CHARACTER SET = `%s` DEFAULT COLLATE = `%s`", ifNotExists, db.Spec.Name, db.Spec.Encoding, db.Spec.Collation))
 
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
+	if err := r.Schemas.Record(ctx, db.Spec.Name, r.ownerMarker(db)); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	log.Info("DB created, setting status")
 
-	db.Status.CreatedAt = metav1.NewTime(time.Now())
+	if drifted {
+		message := fmt.Sprintf("schema %s was dropped outside the operator and has been recreated", db.Spec.Name)
+		r.Recorder.Event(db, v1.EventTypeWarning, "SchemaMissing", message)
+		dbv1alpha1.SetCondition(&db.Status.Conditions, dbv1alpha1.Condition{
+			Type:    dbv1alpha1.ConditionDrifted,
+			Status:  metav1.ConditionTrue,
+			Reason:  "SchemaMissing",
+			Message: message,
+		})
+	} else {
+		db.Status.CreatedAt = metav1.NewTime(time.Now())
+	}
+
+	dbv1alpha1.SetCondition(&db.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ConditionOwnershipConflict,
+		Status: metav1.ConditionFalse,
+	})
+	dbv1alpha1.SetCondition(&db.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ConditionFailed,
+		Status: metav1.ConditionFalse,
+	})
+
+	if err := r.Status().Update(ctx, db); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: r.Resync.After(db.Spec.ResyncInterval)}, nil
+}
+
+// reportFailure decides how a failed reconcile is retried, like the UserReconciler does
+func (r *DatabaseReconciler) reportFailure(ctx context.Context, req ctrl.Request, err error) (ctrl.Result, error) {
+	class := server.ClassifyError(err)
+	if class == server.ErrorTransient {
+		return ctrl.Result{}, err
+	}
+
+	log := r.Log.WithValues("database", req.NamespacedName)
+
+	db := &dbv1alpha1.Database{}
+	if err := r.Get(ctx, req.NamespacedName, db); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	log.Error(err, "reconcile failed, not retrying", "class", class)
+	r.Recorder.Event(db, v1.EventTypeWarning, "ReconcileFailed", err.Error())
+	dbv1alpha1.SetCondition(&db.Status.Conditions, failedCondition(class, err))
 
-	err = r.Status().Update(ctx, db)
+	if err := r.Status().Update(ctx, db); err != nil {
+		return ctrl.Result{}, err
+	}
 
-	return ctrl.Result{}, err
+	if class == server.ErrorConflict {
+		return ctrl.Result{RequeueAfter: r.Resync.After(db.Spec.ResyncInterval)}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// ownerMarker identifies the database as the owner of its schema
+func (r *DatabaseReconciler) ownerMarker(db *dbv1alpha1.Database) ownership.Marker {
+	return ownership.Marker{
+		Cluster:   r.ClusterID,
+		Kind:      "Database",
+		Namespace: db.Namespace,
+		Name:      db.Name,
+		UID:       string(db.UID),
+	}
 }
 
 func (r *DatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dbv1alpha1.Database{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
 
@@ -136,3 +320,14 @@ func removeString(input []string, search string) (output []string) {
 
 	return
 }
+
+// subtractStrings returns the elements of a that aren't in b
+func subtractStrings(a, b []string) (output []string) {
+	for _, i := range a {
+		if !containsString(b, i) {
+			output = append(output, i)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,41 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+)
+
+// clearDrifted sets a Drifted condition left by an earlier repair to False once a resync
+// finds everything in place. It reports whether the condition changed.
+func clearDrifted(conditions *[]dbv1alpha1.Condition, message string) bool {
+	drifted := dbv1alpha1.FindCondition(*conditions, dbv1alpha1.ConditionDrifted)
+	if drifted == nil || drifted.Status == metav1.ConditionFalse {
+		return false
+	}
+
+	dbv1alpha1.SetCondition(conditions, dbv1alpha1.Condition{
+		Type:    dbv1alpha1.ConditionDrifted,
+		Status:  metav1.ConditionFalse,
+		Reason:  "InSync",
+		Message: message,
+	})
+
+	return true
+}
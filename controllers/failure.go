@@ -0,0 +1,39 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+	"github.com/virtualops/sql-operator/server"
+)
+
+// failedCondition describes an error the server will keep returning
+func failedCondition(class server.ErrorClass, err error) dbv1alpha1.Condition {
+	reason := "ServerRefused"
+	if class == server.ErrorConflict {
+		reason = "ServerConflict"
+	}
+
+	return dbv1alpha1.Condition{
+		Type:    dbv1alpha1.ConditionFailed,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: err.Error(),
+	}
+}
@@ -0,0 +1,81 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/virtualops/sql-operator/password"
+	"github.com/virtualops/sql-operator/server"
+)
+
+// passwordRequirements keeps the server's validate_password settings, which can be changed
+// while the server runs. They start out as discovered, and are read again once they're older
+// than the resync interval, or when the server refuses a password that met them.
+type passwordRequirements struct {
+	mu        sync.Mutex
+	variables map[string]string
+	readAt    time.Time
+}
+
+// serverPasswordRequirements returns what the server's validate_password settings require
+func (r *UserReconciler) serverPasswordRequirements() password.Policy {
+	r.passwordRequirements.mu.Lock()
+	defer r.passwordRequirements.mu.Unlock()
+
+	if r.passwordRequirements.variables == nil {
+		return password.Requirements(r.Capabilities.Variables)
+	}
+
+	return password.Requirements(r.passwordRequirements.variables)
+}
+
+// refreshPasswordRequirements reads the validate_password settings again if they're older
+// than the resync interval, or always when forced. It reports whether they changed.
+func (r *UserReconciler) refreshPasswordRequirements(ctx context.Context, force bool) (bool, error) {
+	r.passwordRequirements.mu.Lock()
+	defer r.passwordRequirements.mu.Unlock()
+
+	if !force {
+		// the settings read at discovery count as fresh
+		if r.passwordRequirements.readAt.IsZero() {
+			r.passwordRequirements.readAt = time.Now()
+		}
+
+		if r.Resync.Interval <= 0 || time.Since(r.passwordRequirements.readAt) < r.Resync.Interval {
+			return false, nil
+		}
+	}
+
+	variables, err := server.ReadVariables(ctx, r.DB.DB, server.PasswordVariables)
+
+	if err != nil {
+		return false, err
+	}
+
+	previous := r.passwordRequirements.variables
+	if previous == nil {
+		previous = r.Capabilities.Variables
+	}
+
+	r.passwordRequirements.variables = variables
+	r.passwordRequirements.readAt = time.Now()
+
+	return password.Requirements(previous) != password.Requirements(variables), nil
+}
@@ -0,0 +1,63 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Resync decides how often objects are reconciled against the server even if nothing in
+// the cluster changed, so changes made directly on the server are noticed
+type Resync struct {
+	// Interval is the operator-wide interval, 0 disables resyncing
+	Interval time.Duration
+	// Jitter spreads the resyncs out by up to this fraction of the interval, so objects
+	// reconciled together don't all come back at the same moment
+	Jitter float64
+}
+
+// After is how long until the next resync of an object, taking its own interval over the
+// operator-wide one. It returns 0 if the object shouldn't be resynced.
+func (r Resync) After(override *metav1.Duration) time.Duration {
+	interval := r.Interval
+	if override != nil {
+		interval = override.Duration
+	}
+
+	if interval <= 0 {
+		return 0
+	}
+
+	// wait.Jitter treats a factor of 0 as 1
+	if r.Jitter <= 0 {
+		return interval
+	}
+
+	return wait.Jitter(interval, r.Jitter)
+}
+
+// sooner returns the shorter of two requeue delays, ignoring those that are 0
+func sooner(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,154 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/virtualops/sql-operator/ownership"
+	"github.com/virtualops/sql-operator/server"
+)
+
+// errNotSetUp is reported by the readiness check until the server has been set up
+var errNotSetUp = errors.New("the server hasn't been set up yet")
+
+// ServerSetup discovers what the server supports and creates the bookkeeping table once the
+// server can be reached, retrying with backoff until it succeeds. Reconciles wait for it, so
+// the manager can start while the server is down.
+type ServerSetup struct {
+	DB      *server.DB
+	Schemas *ownership.Schemas
+	// Capabilities is filled in by the discovery, and shared with the reconcilers
+	Capabilities *server.Capabilities
+	// Validate checks the discovered capabilities against the operator's configuration.
+	// An error stops the manager.
+	Validate func(*server.Capabilities) error
+	// Timeout bounds each attempt, 0 leaves it unbounded
+	Timeout time.Duration
+	Log     logr.Logger
+
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (s *ServerSetup) done() chan struct{} {
+	s.once.Do(func() {
+		s.ready = make(chan struct{})
+	})
+
+	return s.ready
+}
+
+// Start sets up the server, returning once it has been or when stop is closed
+func (s *ServerSetup) Start(stop <-chan struct{}) error {
+	backoff := wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: math.MaxInt32, Cap: time.Minute}
+
+	for {
+		err := s.attempt()
+
+		if err == nil {
+			s.Log.Info("discovered server", "flavor", s.Capabilities.Flavor, "version", s.Capabilities.Version.String())
+			close(s.done())
+			return nil
+		}
+
+		var invalid *invalidConfigError
+		if errors.As(err, &invalid) {
+			return invalid.err
+		}
+
+		retryIn := backoff.Step()
+		s.Log.Error(err, "failed to set up the server, retrying", "instance", s.DB.Instance, "retry_in", retryIn.String())
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(retryIn):
+		}
+	}
+}
+
+// invalidConfigError is a configuration the server rejects, which retrying won't fix
+type invalidConfigError struct {
+	err error
+}
+
+func (e *invalidConfigError) Error() string {
+	return e.err.Error()
+}
+
+func (s *ServerSetup) attempt() error {
+	ctx, cancel := reconcileContext(context.Background(), s.Timeout)
+	defer cancel()
+
+	capabilities, err := server.Discover(ctx, s.DB.DB)
+
+	if err != nil {
+		return err
+	}
+
+	if s.Validate != nil {
+		if err := s.Validate(capabilities); err != nil {
+			return &invalidConfigError{err: err}
+		}
+	}
+
+	if err := s.Schemas.EnsureTable(ctx); err != nil {
+		return err
+	}
+
+	*s.Capabilities = *capabilities
+
+	return nil
+}
+
+// NeedLeaderElection lets standby replicas set up the server too, so they become ready
+func (s *ServerSetup) NeedLeaderElection() bool {
+	return false
+}
+
+// Wait blocks until the server has been set up, or ctx is done. A nil ServerSetup doesn't wait.
+func (s *ServerSetup) Wait(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case <-s.done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReadyCheck fails until the server has been set up. It is a healthz.Checker.
+func (s *ServerSetup) ReadyCheck(req *http.Request) error {
+	select {
+	case <-s.done():
+		return nil
+	default:
+		return errNotSetUp
+	}
+}
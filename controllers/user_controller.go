@@ -19,36 +19,111 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"github.com/jmoiron/sqlx"
+	"github.com/virtualops/sql-operator/credentials"
 	"github.com/virtualops/sql-operator/grants"
+	"github.com/virtualops/sql-operator/metrics"
+	"github.com/virtualops/sql-operator/password"
+	"github.com/virtualops/sql-operator/server"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+	"github.com/virtualops/sql-operator/audit"
 )
 
 // UserReconciler reconciles a User object
 type UserReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
-	DB     *sqlx.DB
+	Log          logr.Logger
+	Scheme       *runtime.Scheme
+	DB           *server.DB
+	Capabilities *server.Capabilities
+	Recorder     record.EventRecorder
+	// CredentialSinks are the configured places credentials can be stored
+	CredentialSinks map[dbv1alpha1.CredentialSink]credentials.Sink
+	// DefaultCredentialSink is used for users that don't choose a sink
+	DefaultCredentialSink dbv1alpha1.CredentialSink
+	// ClusterID identifies this cluster in the ownership markers on the server
+	ClusterID string
+	// PasswordHashKey keys the password hashes recorded on the status
+	PasswordHashKey []byte
+	// PasswordGenerator is the policy for generated passwords, unless a User overrides it
+	PasswordGenerator password.Policy
+	// Resync is how often users are compared against the server without a change
+	Resync Resync
+	// Context is cancelled when the manager stops
+	Context context.Context
+	// ReconcileTimeout bounds a single reconcile, 0 leaves it unbounded
+	ReconcileTimeout time.Duration
+	// MaxConcurrentReconciles is how many users are reconciled at once
+	MaxConcurrentReconciles int
+	// Setup is waited for before reconciling, it fills in Capabilities
+	Setup *ServerSetup
+
+	passwordRequirements passwordRequirements
 }
 
 // +kubebuilder:rbac:groups=db.breeze.sh,resources=users,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=db.breeze.sh,resources=users/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups=core,resources=secrets,verbs=create
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 func (r *UserReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+	ctx, cancel := reconcileContext(r.Context, r.ReconcileTimeout)
+	defer cancel()
+
+	// nothing can be done before the server has been set up
+	if err := r.Setup.Wait(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// passwords are generated to meet the server's validate_password settings
+	if _, err := r.refreshPasswordRequirements(ctx, false); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcile(ctx, req)
+
+	// a generated password the server refuses means its settings changed since they were
+	// read, so the user is retried with the new ones. If they didn't change, the password
+	// is refused for good.
+	if server.IsPasswordPolicyError(err) {
+		changed, refreshErr := r.refreshPasswordRequirements(ctx, true)
+
+		if refreshErr != nil {
+			return ctrl.Result{}, refreshErr
+		}
+
+		if changed {
+			r.Log.Info("validate_password settings changed, retrying", "user", req.NamespacedName)
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	if err != nil {
+		return r.reportFailure(ctx, req, err)
+	}
+
+	return result, nil
+}
+
+func (r *UserReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("user", req.NamespacedName)
 
 	user := &dbv1alpha1.User{}
@@ -64,7 +139,18 @@ func (r *UserReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
-	log.Info("got user", "user", user)
+	log.V(1).Info("got user", "generation", user.Generation)
+
+	// statements are audited as run for this user
+	ctx = audit.WithSubject(ctx, "User", user)
+
+	// users sharing an account, by mistake or while one is renamed, take turns
+	unlock, err := r.DB.Locks.Lock(ctx, accountLockKey(appliedUsername(user)), accountLockKey(r.username(user)))
+
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer unlock()
 
 	finalizerName := "db.breeze.sh/finalizer"
 
@@ -82,45 +168,161 @@ func (r *UserReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		if containsString(user.ObjectMeta.Finalizers, finalizerName) {
 			// our finalizer is present, so lets handle our external dependency
 
-			if _, err := r.DB.Exec(fmt.Sprintf("DROP USER `%s`", user.Spec.Username)); err != nil {
-				// if DB deletion fails, fail reconciliation
+			accounts, err := r.readAccounts(ctx, appliedUsername(user))
+
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+
+			for _, a := range appliedAccounts(user) {
+				// accounts marked as someone else's are left alone
+				if marker := accounts[a.Host].Owner; marker != nil && !marker.SameOwner(r.ownerMarker(user)) {
+					log.Info("not dropping account managed elsewhere", "account", a.String(), "owner", marker.String())
+					r.Recorder.Eventf(user, v1.EventTypeWarning, "AccountOwnedElsewhere", "Account %s is managed by %s and wasn't dropped", a, marker)
+					continue
+				}
+
+				if err := r.dropAccount(ctx, a); err != nil {
+					// if DB deletion fails, fail reconciliation
+					return ctrl.Result{}, err
+				}
+			}
+
+			// copies in other namespaces aren't garbage collected with the user
+			if err := r.deleteSecretCopies(ctx, user, nil); err != nil {
+				return ctrl.Result{}, err
+			}
+
+			_, sink, err := r.credentialSink(user)
+
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+
+			if err := sink.Delete(ctx, credentialsLocation(user)); err != nil {
 				return ctrl.Result{}, err
 			}
 
 			// If the deletion succeeded, remove the finalizer so deletion can complete
 			user.ObjectMeta.Finalizers = removeString(user.ObjectMeta.Finalizers, finalizerName)
-			if err := r.Update(context.Background(), user); err != nil {
+			if err := r.Update(ctx, user); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
+
+		return ctrl.Result{}, nil
 	}
 
+	// Anything the server can't do is reported on the status instead of failing on the SQL
+	if err := r.validateCapabilities(user); err != nil {
+		if unsupported, ok := err.(*server.UnsupportedError); ok {
+			log.Info("user spec not supported by server", "reason", unsupported.Reason)
+			dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+				Type:    dbv1alpha1.ConditionUnsupported,
+				Status:  metav1.ConditionTrue,
+				Reason:  unsupported.Reason,
+				Message: unsupported.Message,
+			})
+
+			return ctrl.Result{}, r.Status().Update(ctx, user)
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ConditionUnsupported,
+		Status: metav1.ConditionFalse,
+	})
+
+	// The credentials secret has to be ours to write before any account is created
+	if err := r.claimCredentials(ctx, user); err != nil {
+		return r.reportConflict(ctx, user, err)
+	}
+
+	dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ConditionSecretConflict,
+		Status: metav1.ConditionFalse,
+	})
+
+	// Neither can the username of new or renamed accounts belong to anyone else
+	if err := r.checkUsername(ctx, user); err != nil {
+		return r.reportConflict(ctx, user, err)
+	}
+
+	dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ConditionUsernameConflict,
+		Status: metav1.ConditionFalse,
+	})
+
+	// A single catalog query tells which accounts exist, what state they're in and what
+	// they're granted. When nothing changed, it's the only query a resync makes on the server.
+	accounts, err := r.readAccounts(ctx, appliedUsername(user))
+
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Existing accounts must be marked as this user's before they're changed
+	if err := r.checkOwnership(ctx, user, accounts); err != nil {
+		return r.reportConflict(ctx, user, err)
+	}
+
+	dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ConditionOwnershipConflict,
+		Status: metav1.ConditionFalse,
+	})
+
+	// The credentials written when creating the user may not be in the cache yet, so that
+	// reconcile keeps using the password it created them with
+	var createdPassword string
+
 	// If we don't have a creation timestamp, we'll create the user
 	if user.Status.CreatedAt.IsZero() {
-		password := rand.String(16)
-		_, err := r.DB.Exec(fmt.Sprintf("CREATE USER '%s'@'%s' IDENTIFIED BY '%s'", user.Spec.Username, user.Spec.Host, password))
+		sinkName, _, err := r.credentialSink(user)
 
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 
-		// We'll store the credentials in a secret
-		err = r.Create(ctx, &v1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      user.Spec.SecretName,
-				Namespace: user.Namespace,
-				OwnerReferences: []metav1.OwnerReference{{
-					APIVersion: user.APIVersion,
-					Kind:       user.Kind,
-					Name:       user.Name,
-					UID:        user.UID,
-				}},
-			},
-			StringData: map[string]string{
-				"DB_USERNAME": user.Spec.Username,
-				"DB_PASSWORD": password,
-			},
-		})
+		password, err := r.initialPassword(ctx, user)
+
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err := r.dropPendingAccounts(ctx, user); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err := r.claimUsername(ctx, user); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		existing, err := r.readAccounts(ctx, r.username(user))
+
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		for _, host := range userHosts(user) {
+			a := account{Username: r.username(user), Host: host}
+
+			// an earlier attempt created it, but didn't get to store its password
+			if _, ok := existing[host]; ok {
+				if err := r.alterAuthentication(ctx, user, a, password); err != nil {
+					return ctrl.Result{}, err
+				}
+				continue
+			}
+
+			if err := r.createAccount(ctx, user, a, password); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		// We'll store the credentials in the user's sink. A secret exists already if it was adopted or merged into.
+		err = r.writeCredentials(ctx, user, map[string]string{secretKeyUsername: r.username(user), secretKeyPassword: password})
 
 		if err != nil {
 			return ctrl.Result{}, err
@@ -128,48 +330,572 @@ func (r *UserReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 		log.WithValues("secret_name", user.Spec.SecretName).Info("stored credentials")
 		user.Status.CreatedAt = metav1.NewTime(time.Now())
+		user.Status.Username = r.username(user)
+		user.Status.PendingUsername = ""
+		user.Status.Host = user.Spec.Host
+		user.Status.Hosts = userHosts(user)
+		user.Status.AuthenticationPlugin = authenticationPlugin(user)
+		r.setPassword(user, password)
+		user.Status.CredentialSink = sinkName
 
 		err = r.Status().Update(ctx, user)
 
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+
+		if accounts, err = r.readAccounts(ctx, r.username(user)); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		createdPassword = password
 	}
 
-	executionPlan := grants.GenerateExecutionPlan(user.Status.CurrentGrants, user.Spec.Grants)
+	// Users created before sinks were recorded kept their credentials in a secret, which is
+	// recorded so a sink named in the spec later moves them out of it
+	if user.Status.CredentialSink == "" {
+		user.Status.CredentialSink = dbv1alpha1.CredentialSinkSecret
+	}
 
-	for _, grant := range executionPlan.Grant {
-		privilegeString := getPrivilegeExpression(grant)
-		_, err := r.DB.Exec(fmt.Sprintf("GRANT %s ON %s TO '%s'@'%s'", privilegeString, grant.Target, user.Spec.Username, user.Spec.Host))
+	// A deleted or damaged credentials secret is restored before anything reads from it
+	if createdPassword == "" {
+		if err := r.restoreCredentials(ctx, user, accounts); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// A changed username or primary host renames the existing accounts in place,
+	// so their password and grants carry over
+	if appliedUsername(user) != r.username(user) || appliedHost(user) != user.Spec.Host {
+		if user.Spec.RenamePolicy == dbv1alpha1.RenamePolicyRefuse {
+			log.Info("refusing to rename user", "from", appliedUsername(user), "to", r.username(user))
+			dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+				Type:    dbv1alpha1.ConditionRenameRefused,
+				Status:  metav1.ConditionTrue,
+				Reason:  "RenamePolicyRefuse",
+				Message: fmt.Sprintf("username and host can't change from %s", account{Username: appliedUsername(user), Host: appliedHost(user)}),
+			})
+
+			return ctrl.Result{}, r.Status().Update(ctx, user)
+		}
+
+		if err := r.claimUsername(ctx, user); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err := r.renameAccounts(ctx, user); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		err = r.Status().Update(ctx, user)
 
-		// right now, the `user.status` will be absolutely whack if this errors on any but the first grant,
-		// since we will have granted permissions and then errored, which means the status reflects the
-		// pre-grant state instead of properly accounting for the previous iteration's applied grant.
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+
+		if accounts, err = r.readAccounts(ctx, r.username(user)); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
-	for _, grant := range executionPlan.Revoke {
-		privilegeString := getPrivilegeExpression(grant)
-		_, err := r.DB.Exec(fmt.Sprintf("REVOKE %s ON %s FROM '%s'@'%s'", privilegeString, grant.Target, user.Spec.Username, user.Spec.Host))
+	dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ConditionRenameRefused,
+		Status: metav1.ConditionFalse,
+	})
+
+	desiredHosts := userHosts(user)
+	currentHosts := appliedHosts(user)
+
+	// accounts dropped outside the operator are created again below like added hosts,
+	// with the password that was rotated on the ones that are left
+	missingHosts, password, err := r.healAccounts(ctx, user, currentHosts, accounts)
+
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	currentHosts = subtractStrings(currentHosts, missingHosts)
+
+	if password == "" {
+		password = createdPassword
+	}
+
+	if password == "" {
+		password, err = r.readPassword(ctx, user)
 
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
+	// users created before the password hash was tracked are assumed to be in sync
+	if user.Status.PasswordHash == "" {
+		user.Status.PasswordHash = r.hashPassword(password)
+	}
+	passwordChanged := r.passwordChanged(user, password)
+
+	// accounts for hosts that were added share the password stored in the secret,
+	// and start out without grants so they get the full grant plan below
+	addedHosts := subtractStrings(desiredHosts, currentHosts)
+	if len(addedHosts) > 0 {
+		for _, host := range addedHosts {
+			a := account{Username: r.username(user), Host: host}
+
+			// an earlier attempt created it with the stored password, but failed before the
+			// hosts were saved. It's identified again below if the password or plugin changed
+			// since; identifying it with the same password would be refused under a password
+			// history policy.
+			if _, ok := accounts[host]; ok {
+				continue
+			}
+
+			if err := r.createAccount(ctx, user, a, password); err != nil {
+				return ctrl.Result{}, err
+			}
+			log.Info("created account for added host", "host", host)
+		}
+
+		// new accounts start out without a REQUIRE option
+		user.Status.TLSRequire = ""
+	}
+
+	for _, host := range subtractStrings(currentHosts, desiredHosts) {
+		if err := r.dropAccount(ctx, account{Username: r.username(user), Host: host}); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("dropped account for removed host", "host", host)
+	}
+
+	// plugin changes and edited passwords are both applied by identifying the accounts again
+	if authenticationPlugin(user) != user.Status.AuthenticationPlugin || passwordChanged {
+		for _, host := range desiredHosts {
+			if err := r.alterAuthentication(ctx, user, account{Username: r.username(user), Host: host}, password); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		log.Info("identified accounts", "plugin", authenticationPlugin(user), "password_changed", passwordChanged)
+		user.Status.AuthenticationPlugin = authenticationPlugin(user)
+	}
+
+	// also replaces hashes recorded before they were keyed
+	r.setPassword(user, password)
+
+	// a password from the password secret is mirrored into the credentials secret
+	if user.Spec.PasswordSecretRef != nil {
+		if err := r.writeCredentials(ctx, user, map[string]string{secretKeyPassword: password}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := r.reconcileAccountState(ctx, user, desiredHosts, accounts); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	renewCertificateIn, err := r.reconcileTLS(ctx, user, desiredHosts)
+
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// copies are synced after the certificate, so they pick up renewals too
+	if err := r.reconcileSecretTargets(ctx, user); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the grants read along with the accounts are compared against the spec, so privileges
+	// revoked or granted outside the operator on the targets it manages are put back in line.
+	// Accounts created during this reconcile hold none.
+	var driftedHosts []string
+	for _, host := range desiredHosts {
+		a := account{Username: r.username(user), Host: host}
+		held := accounts[host].Grants
+		expectedGrants := user.Status.CurrentGrants
+		if containsString(addedHosts, host) {
+			expectedGrants = nil
+		}
+
+		if !grants.Equal(expectedGrants, held.Current(user.Status.CurrentGrants, user.Spec.Grants)) {
+			driftedHosts = append(driftedHosts, host)
+		}
+
+		// grants applied before schema names were escaped are revoked from the unescaped
+		// schema, which matches other schemas too
+		currentGrants := held.Current(user.Status.CurrentGrants, user.Spec.Grants, grants.LegacyGrants(user.Status.CurrentGrants))
+		executionPlan := grants.GenerateExecutionPlan(currentGrants, user.Spec.Grants)
+
+		// right now, the `user.status` will be absolutely whack if this errors on any but the first grant,
+		// since we will have granted permissions and then errored, which means the status reflects the
+		// pre-grant state instead of properly accounting for the previous iteration's applied grant.
+		if err := r.applyExecutionPlan(ctx, a, executionPlan); err != nil {
+			// a grant that's already gone was revoked outside the operator
+			if server.ClassifyError(err) == server.ErrorConflict {
+				metrics.DriftDetections.WithLabelValues(r.DB.Instance, "grant").Inc()
+			}
+
+			return ctrl.Result{}, err
+		}
+	}
+
+	if len(driftedHosts) > 0 {
+		metrics.DriftDetections.WithLabelValues(r.DB.Instance, "grant").Add(float64(len(driftedHosts)))
+
+		message := fmt.Sprintf("grants for %s on hosts %s were changed outside the operator and have been restored", r.username(user), strings.Join(driftedHosts, ", "))
+		log.Info("grants drifted", "hosts", driftedHosts)
+		r.Recorder.Event(user, v1.EventTypeWarning, "GrantsChanged", message)
+		dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+			Type:    dbv1alpha1.ConditionDrifted,
+			Status:  metav1.ConditionTrue,
+			Reason:  "GrantsChanged",
+			Message: message,
+		})
+	}
+
+	user.Status.Hosts = desiredHosts
 	user.Status.CurrentGrants = user.Spec.Grants
+	dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ConditionFailed,
+		Status: metav1.ConditionFalse,
+	})
 
 	err = r.Status().Update(ctx, user)
 
 	if err != nil {
-		return ctrl.Result{}, nil
+		return ctrl.Result{}, err
+	}
+
+	// come back when the client certificate is due for renewal, or to resync
+	return ctrl.Result{RequeueAfter: sooner(renewCertificateIn, r.Resync.After(user.Spec.ResyncInterval))}, nil
+}
+
+// reconcileAccountState reads the effective state of the user's accounts from the server and
+// brings their resource limits, password policy and lock state in line with the spec. The
+// observed state is recorded on the status.
+func (r *UserReconciler) reconcileAccountState(ctx context.Context, user *dbv1alpha1.User, hosts []string, accounts map[string]accountState) error {
+	primary := account{Username: r.username(user), Host: hosts[0]}
+
+	// accounts created during this reconcile weren't there when the accounts were read
+	var err error
+	state, ok := accounts[primary.Host]
+	if !ok {
+		if state, err = r.readAccountState(ctx, primary); err != nil {
+			return err
+		}
+	}
+
+	var options []string
+
+	if user.Spec.Limits != nil && *user.Spec.Limits != state.Limits {
+		options = append(options, resourceOptions(*user.Spec.Limits))
+	}
+
+	// the password policy can't be read back as a whole, so we compare against what we last applied
+	if user.Spec.PasswordPolicy != nil && !reflect.DeepEqual(user.Spec.PasswordPolicy, user.Status.PasswordPolicy) {
+		options = append(options, passwordOptions(*user.Spec.PasswordPolicy))
+	}
+
+	if r.Capabilities.Supports(server.FeatureAccountLock) && user.Spec.Locked != state.Locked {
+		options = append(options, lockOption(user.Spec.Locked))
+	}
+
+	if len(options) > 0 {
+		for _, host := range hosts {
+			if err := r.alterAccount(ctx, account{Username: r.username(user), Host: host}, strings.Join(options, " ")); err != nil {
+				return err
+			}
+		}
+
+		r.Log.Info("altered accounts", "user", r.username(user), "options", len(options))
+
+		if state, err = r.readAccountState(ctx, primary); err != nil {
+			return err
+		}
+	}
+
+	user.Status.PasswordPolicy = user.Spec.PasswordPolicy
+	user.Status.Limits = &state.Limits
+	user.Status.Locked = state.Locked
+	user.Status.PasswordExpired = state.PasswordExpired
+	user.Status.PasswordExpiresAt = nil
+
+	if expiresAt := r.passwordExpiry(state); expiresAt != nil {
+		t := metav1.NewTime(*expiresAt)
+		user.Status.PasswordExpiresAt = &t
+	}
+
+	return nil
+}
+
+// passwordExpiry works out when the account's password expires, falling back to the
+// server's default_password_lifetime. It returns nil if the password never expires.
+func (r *UserReconciler) passwordExpiry(state accountState) *time.Time {
+	if state.PasswordLastChanged == nil {
+		return nil
+	}
+
+	var days int64
+	if state.PasswordLifetime != nil {
+		days = *state.PasswordLifetime
+	} else if value, ok := r.Capabilities.Variable("default_password_lifetime"); ok {
+		days, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	if days <= 0 {
+		return nil
+	}
+
+	expiresAt := state.PasswordLastChanged.AddDate(0, 0, int(days))
+
+	return &expiresAt
+}
+
+// accountRename renames one account
+type accountRename struct {
+	From account
+	To   account
+}
+
+// planRename works out how the applied accounts are renamed to the spec's username and
+// primary host, and the hosts they end up on. RENAME USER can't replace an account, so when
+// the user already has one on the new primary host, that one is kept and the old primary
+// account keeps its host if the spec still has it, or is dropped if it doesn't.
+func planRename(user *dbv1alpha1.User, username string) ([]accountRename, []account, []string) {
+	oldHost := appliedHost(user)
+	newHostApplied := user.Spec.Host != oldHost && containsString(appliedHosts(user), user.Spec.Host)
+
+	var renames []accountRename
+	var drops []account
+	var hosts []string
+	for _, from := range appliedAccounts(user) {
+		to := account{Username: username, Host: from.Host}
+		if from.Host == oldHost && !newHostApplied {
+			to.Host = user.Spec.Host
+		}
+
+		if from.Host == oldHost && newHostApplied && !containsString(userHosts(user), oldHost) {
+			drops = append(drops, from)
+			continue
+		}
+
+		renames = append(renames, accountRename{From: from, To: to})
+		hosts = append(hosts, to.Host)
+	}
+
+	return renames, drops, hosts
+}
+
+// renameAccounts renames every applied account to the spec's username and primary host,
+// then points the credentials secret at the new username
+func (r *UserReconciler) renameAccounts(ctx context.Context, user *dbv1alpha1.User) error {
+	renames, drops, hosts := planRename(user, r.username(user))
+
+	current, err := r.readAccounts(ctx, appliedUsername(user))
+
+	if err != nil {
+		return err
+	}
+
+	for _, a := range drops {
+		// dropped by an earlier attempt that didn't finish
+		if _, ok := current[a.Host]; !ok {
+			continue
+		}
+
+		if err := r.dropAccount(ctx, a); err != nil {
+			return err
+		}
+
+		r.Log.Info("dropped account replaced by the new primary host", "account", a.String())
+	}
+
+	renamed, err := r.readAccounts(ctx, r.username(user))
+
+	if err != nil {
+		return err
+	}
+
+	for _, rename := range renames {
+		// accounts keeping their name, or renamed by an earlier attempt that didn't finish
+		if _, ok := renamed[rename.To.Host]; ok {
+			continue
+		}
+
+		if err := r.renameAccount(ctx, rename.From, rename.To); err != nil {
+			return err
+		}
+
+		r.Log.Info("renamed account", "from", rename.From.String(), "to", rename.To.String())
+	}
+
+	if err := r.writeCredentials(ctx, user, map[string]string{secretKeyUsername: r.username(user)}); err != nil {
+		return err
+	}
+
+	user.Status.Username = r.username(user)
+	user.Status.PendingUsername = ""
+	user.Status.Host = user.Spec.Host
+	user.Status.Hosts = hosts
+
+	return nil
+}
+
+// conflictError is returned when something the user needs belongs to someone else.
+// It is reported on the given condition rather than retried.
+type conflictError struct {
+	Condition string
+	Reason    string
+	Message   string
+}
+
+func (e *conflictError) Error() string {
+	return e.Message
+}
+
+// reportConflict sets the condition of a conflictError and stops reconciling until the
+// next resync, passing on any other error
+func (r *UserReconciler) reportConflict(ctx context.Context, user *dbv1alpha1.User, err error) (ctrl.Result, error) {
+	conflict, ok := err.(*conflictError)
+	if !ok {
+		return ctrl.Result{}, err
+	}
+
+	r.Log.Info("user conflicts", "user", user.Name, "condition", conflict.Condition, "reason", conflict.Reason)
+	dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+		Type:    conflict.Condition,
+		Status:  metav1.ConditionTrue,
+		Reason:  conflict.Reason,
+		Message: conflict.Message,
+	})
+
+	if err := r.Status().Update(ctx, user); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// conflicts can clear up without the user changing, such as when the other party goes away
+	return ctrl.Result{RequeueAfter: r.Resync.After(user.Spec.ResyncInterval)}, nil
+}
+
+// reportFailure decides how a failed reconcile is retried. Transient errors are returned, so
+// the request is retried with backoff. Errors the server will keep returning are recorded in
+// the Failed condition instead: terminal ones wait for the User to change, and conflicts are
+// tried again at the next resync.
+func (r *UserReconciler) reportFailure(ctx context.Context, req ctrl.Request, err error) (ctrl.Result, error) {
+	class := server.ClassifyError(err)
+	if class == server.ErrorTransient {
+		return ctrl.Result{}, err
+	}
+
+	log := r.Log.WithValues("user", req.NamespacedName)
+
+	// the user we were reconciling may hold status that was never applied
+	user := &dbv1alpha1.User{}
+	if err := r.Get(ctx, req.NamespacedName, user); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	log.Error(err, "reconcile failed, not retrying", "class", class)
+	r.Recorder.Event(user, v1.EventTypeWarning, "ReconcileFailed", err.Error())
+	dbv1alpha1.SetCondition(&user.Status.Conditions, failedCondition(class, err))
+
+	if err := r.Status().Update(ctx, user); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if class == server.ErrorConflict {
+		return ctrl.Result{RequeueAfter: r.Resync.After(user.Spec.ResyncInterval)}, nil
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// validateCapabilities checks the spec against the server's capabilities
+func (r *UserReconciler) validateCapabilities(user *dbv1alpha1.User) error {
+	if limit := r.Capabilities.MaxUsernameLength(); len(user.Spec.Username) > limit {
+		return &server.UnsupportedError{
+			Reason:  "UsernameTooLong",
+			Message: fmt.Sprintf("username %s is longer than the %d characters %s %s allows", user.Spec.Username, limit, r.Capabilities.Flavor, r.Capabilities.Version),
+		}
+	}
+
+	if err := r.Capabilities.ValidateAuthenticationPlugin(authenticationPlugin(user)); err != nil {
+		return err
+	}
+
+	if policy := user.Spec.PasswordPolicy; policy != nil {
+		if policy.ExpireAfterDays != nil {
+			if err := r.Capabilities.Require(server.FeaturePasswordExpiry); err != nil {
+				return err
+			}
+		}
+
+		if policy.History != nil {
+			if err := r.Capabilities.Require(server.FeaturePasswordHistory); err != nil {
+				return err
+			}
+		}
+
+		if policy.FailedLoginAttempts != nil || policy.LockTimeDays != nil {
+			if err := r.Capabilities.Require(server.FeatureFailedLoginTracking); err != nil {
+				return err
+			}
+		}
+	}
+
+	if user.Spec.PasswordSecretRef == nil {
+		if err := r.passwordGenerator(user).Validate(); err != nil {
+			return &server.UnsupportedError{
+				Reason:  "UnsatisfiablePasswordGenerator",
+				Message: fmt.Sprintf("can't generate passwords the server accepts: %s", err),
+			}
+		}
+	}
+
+	if user.Spec.Locked {
+		if err := r.Capabilities.Require(server.FeatureAccountLock); err != nil {
+			return err
+		}
+	}
+
+	for _, grant := range user.Spec.Grants {
+		if err := r.Capabilities.ValidatePrivileges(grant.Privileges); err != nil {
+			return err
+		}
+
+		// with partial revokes enabled, the server matches wildcards in schema names literally
+		if grant.Pattern && r.Capabilities.Supports(server.FeaturePartialRevokes) {
+			return &server.UnsupportedError{
+				Reason:  "UnsupportedPattern",
+				Message: fmt.Sprintf("pattern grant on %s can't be applied while partial_revokes is enabled", grant.Target),
+			}
+		}
+
+		if len(grant.Exclude) == 0 {
+			continue
+		}
+
+		if grant.Target != "*.*" {
+			return &server.UnsupportedError{
+				Reason:  "InvalidExclude",
+				Message: fmt.Sprintf("exclude is only supported on *.* grants, not %s", grant.Target),
+			}
+		}
+
+		if err := r.Capabilities.Require(server.FeaturePartialRevokes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ifExists returns the `IF EXISTS` clause for account statements if the server supports it
+func (r *UserReconciler) ifExists() string {
+	if r.Capabilities.Supports(server.FeatureIfExists) {
+		return "IF EXISTS "
+	}
+
+	return ""
+}
+
 func getPrivilegeExpression(g dbv1alpha1.GrantSpec) string {
 	privilegeString := ""
 	if len(g.Privileges) == 1 && g.Privileges[0] == "*" {
@@ -181,7 +907,108 @@ func getPrivilegeExpression(g dbv1alpha1.GrantSpec) string {
 }
 
 func (r *UserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// index users by the secrets they read, so secret changes can be mapped back to them
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &dbv1alpha1.User{}, secretNameField, func(o runtime.Object) []string {
+		user := o.(*dbv1alpha1.User)
+		names := []string{user.Spec.SecretName}
+		if user.Spec.PasswordSecretRef != nil {
+			names = append(names, user.Spec.PasswordSecretRef.Name)
+		}
+
+		return names
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &dbv1alpha1.User{}, secretTargetNamespaceField, func(o runtime.Object) []string {
+		var namespaces []string
+		for _, target := range secretTargets(o.(*dbv1alpha1.User)) {
+			namespaces = append(namespaces, target.Namespace)
+		}
+
+		return namespaces
+	})
+
+	if err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dbv1alpha1.User{}).
+		Owns(&v1.Secret{}).
+		Watches(&source.Kind{Type: &v1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.usersForSecret),
+		}).
+		// users sharing a secret are flagged, so they're rechecked when one of them changes or goes away
+		Watches(&source.Kind{Type: &dbv1alpha1.User{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.usersSharingSecret),
+		}).
+		// namespaces opting in or out of credential copies change where copies go
+		Watches(&source.Kind{Type: &v1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.usersTargetingNamespace),
+		}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
+
+// usersForSecret maps a secret to the users that read their password from it,
+// or to the user it is a credentials copy of
+func (r *UserReconciler) usersForSecret(o handler.MapObject) []reconcile.Request {
+	var requests []reconcile.Request
+	if owner, ok := o.Meta.GetAnnotations()[copyOwnerAnnotation]; ok {
+		if parts := strings.SplitN(owner, "/", 2); len(parts) == 2 {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: parts[0], Name: parts[1]}})
+		}
+	}
+
+	users := &dbv1alpha1.UserList{}
+	err := r.List(context.Background(), users, client.InNamespace(o.Meta.GetNamespace()), client.MatchingFields{secretNameField: o.Meta.GetName()})
+
+	if err != nil {
+		r.Log.Error(err, "failed to list users for secret", "secret", o.Meta.GetName())
+		return requests
+	}
+
+	for _, user := range users.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: user.Namespace, Name: user.Name}})
+	}
+
+	return requests
+}
+
+// usersSharingSecret maps a user to the users reading the same credentials secret
+func (r *UserReconciler) usersSharingSecret(o handler.MapObject) []reconcile.Request {
+	user, ok := o.Object.(*dbv1alpha1.User)
+	if !ok {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, request := range r.usersForSecret(handler.MapObject{Meta: &metav1.ObjectMeta{Namespace: user.Namespace, Name: user.Spec.SecretName}}) {
+		if request.Name != user.Name {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+// usersTargetingNamespace maps a namespace to the users copying their credentials into it
+func (r *UserReconciler) usersTargetingNamespace(o handler.MapObject) []reconcile.Request {
+	users := &dbv1alpha1.UserList{}
+	err := r.List(context.Background(), users, client.MatchingFields{secretTargetNamespaceField: o.Meta.GetName()})
+
+	if err != nil {
+		r.Log.Error(err, "failed to list users for namespace", "namespace", o.Meta.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, user := range users.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: user.Namespace, Name: user.Name}})
+	}
+
+	return requests
+}
@@ -0,0 +1,260 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+	"github.com/virtualops/sql-operator/credentials"
+)
+
+// credentialSink returns where the user's credentials are stored. Users keep the sink
+// they were created with when the operator's default changes, and users created before
+// sinks were recorded kept theirs in a secret.
+func (r *UserReconciler) credentialSink(user *dbv1alpha1.User) (dbv1alpha1.CredentialSink, credentials.Sink, error) {
+	name := user.Spec.CredentialSink
+	if name == "" {
+		name = user.Status.CredentialSink
+	}
+	if name == "" && !user.Status.CreatedAt.IsZero() {
+		name = dbv1alpha1.CredentialSinkSecret
+	}
+	if name == "" {
+		name = r.DefaultCredentialSink
+	}
+	if name == "" {
+		name = dbv1alpha1.CredentialSinkSecret
+	}
+
+	sink, ok := r.CredentialSinks[name]
+	if !ok {
+		return name, nil, fmt.Errorf("credential sink %s is not configured", name)
+	}
+
+	return name, sink, nil
+}
+
+// credentialsLocation is where the user's credentials are stored in its sink
+func credentialsLocation(user *dbv1alpha1.User) credentials.Location {
+	return credentials.Location{Namespace: user.Namespace, Name: user.Spec.SecretName, Owner: user}
+}
+
+// readCredentials reads the user's stored credentials
+func (r *UserReconciler) readCredentials(ctx context.Context, user *dbv1alpha1.User) (map[string][]byte, error) {
+	_, sink, err := r.credentialSink(user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sink.Read(ctx, credentialsLocation(user))
+}
+
+// writeCredentials sets keys in the user's stored credentials, creating them if needed
+func (r *UserReconciler) writeCredentials(ctx context.Context, user *dbv1alpha1.User, data map[string]string) error {
+	_, sink, err := r.credentialSink(user)
+
+	if err != nil {
+		return err
+	}
+
+	values := map[string][]byte{}
+	for key, value := range data {
+		values[key] = []byte(value)
+	}
+
+	return sink.Write(ctx, credentialsLocation(user), values)
+}
+
+// claimCredentials makes sure the user may write its credentials secret, following its
+// secret policy. It runs before any account is touched, so a conflict leaves the server alone.
+func (r *UserReconciler) claimCredentials(ctx context.Context, user *dbv1alpha1.User) error {
+	// ownership and secret policies only apply to kubernetes secrets
+	sinkName, _, err := r.credentialSink(user)
+
+	if err != nil {
+		return err
+	}
+
+	var secret *v1.Secret
+	if sinkName == dbv1alpha1.CredentialSinkSecret {
+		secret = &v1.Secret{}
+		err = r.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Spec.SecretName}, secret)
+
+		if errors.IsNotFound(err) {
+			secret = nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// secrets created before they were controlled by their user only carry a plain owner reference,
+	// and the user that owns the secret keeps it when other users name it too
+	if secret != nil {
+		for _, owner := range secret.OwnerReferences {
+			if owner.UID == user.UID {
+				return nil
+			}
+		}
+	}
+
+	users := &dbv1alpha1.UserList{}
+	err = r.List(ctx, users, client.InNamespace(user.Namespace), client.MatchingFields{secretNameField: user.Spec.SecretName})
+
+	if err != nil {
+		return err
+	}
+
+	var others []string
+	for _, other := range users.Items {
+		if other.UID != user.UID && other.Spec.SecretName == user.Spec.SecretName {
+			others = append(others, other.Name)
+		}
+	}
+
+	if len(others) > 0 {
+		return &conflictError{
+			Condition: dbv1alpha1.ConditionSecretConflict,
+			Reason:    "DuplicateSecretName",
+			Message:   fmt.Sprintf("secret %s is also used by users: %s", user.Spec.SecretName, strings.Join(others, ", ")),
+		}
+	}
+
+	if secret == nil {
+		return nil
+	}
+
+	for _, owner := range secret.OwnerReferences {
+		if owner.Kind == "User" && strings.HasPrefix(owner.APIVersion, dbv1alpha1.GroupVersion.Group+"/") {
+			return &conflictError{
+				Condition: dbv1alpha1.ConditionSecretConflict,
+				Reason:    "SecretOwnedByUser",
+				Message:   fmt.Sprintf("secret %s belongs to user %s", secret.Name, owner.Name),
+			}
+		}
+	}
+
+	switch user.Spec.SecretPolicy {
+	case dbv1alpha1.SecretPolicyMerge:
+		return nil
+	case dbv1alpha1.SecretPolicyAdopt:
+		if controller := metav1.GetControllerOf(secret); controller != nil {
+			return &conflictError{
+				Condition: dbv1alpha1.ConditionSecretConflict,
+				Reason:    "SecretOwned",
+				Message:   fmt.Sprintf("secret %s can't be adopted, it is controlled by %s %s", secret.Name, controller.Kind, controller.Name),
+			}
+		}
+
+		if err := controllerutil.SetControllerReference(user, secret, r.Scheme); err != nil {
+			return err
+		}
+
+		r.Log.Info("adopted credentials secret", "user", user.Name, "secret", secret.Name)
+		return r.Update(ctx, secret)
+	default:
+		return &conflictError{
+			Condition: dbv1alpha1.ConditionSecretConflict,
+			Reason:    "SecretExists",
+			Message:   fmt.Sprintf("secret %s already exists, set secretPolicy to Adopt or Merge to use it", secret.Name),
+		}
+	}
+}
+
+// restoreCredentials recreates the credentials if they were deleted, or repairs them if
+// their keys were removed or DB_USERNAME was changed. The password they held can't be
+// trusted anymore, so the accounts are rotated to a fresh one. Moving a user to another
+// credential sink is handled the same way, and the credentials in the old one are deleted.
+func (r *UserReconciler) restoreCredentials(ctx context.Context, user *dbv1alpha1.User, accounts map[string]accountState) error {
+	username := appliedUsername(user)
+
+	sinkName, _, err := r.credentialSink(user)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := r.readCredentials(ctx, user)
+
+	var reason string
+	switch {
+	case err == credentials.ErrNotFound:
+		reason = "CredentialsMissing"
+	case err != nil:
+		return err
+	case data[secretKeyPassword] == nil || string(data[secretKeyUsername]) != username:
+		reason = "CredentialsChanged"
+	default:
+		return nil
+	}
+
+	password, err := r.initialPassword(ctx, user)
+
+	if err != nil {
+		return err
+	}
+
+	// the credentials are written first, so an ALTER that fails is retried by the password sync
+	if err := r.writeCredentials(ctx, user, map[string]string{secretKeyUsername: username, secretKeyPassword: password}); err != nil {
+		return err
+	}
+
+	// a password from the password secret ref is still known, so only generated ones are rotated
+	if r.passwordChanged(user, password) {
+		for _, a := range appliedAccounts(user) {
+			// missing accounts are recreated with the password from the credentials
+			if _, ok := accounts[a.Host]; !ok {
+				continue
+			}
+
+			if err := r.alterAuthentication(ctx, user, a, password); err != nil {
+				return err
+			}
+		}
+	}
+
+	if previous, ok := r.CredentialSinks[user.Status.CredentialSink]; ok && user.Status.CredentialSink != sinkName {
+		if err := previous.Delete(ctx, credentialsLocation(user)); err != nil {
+			return err
+		}
+
+		reason = "CredentialSinkChanged"
+	}
+
+	r.Log.Info("restored credentials", "user", user.Name, "reason", reason, "sink", sinkName)
+	r.Recorder.Eventf(user, v1.EventTypeWarning, reason, "Credentials %s in %s were missing or changed, restored them with a new password", user.Spec.SecretName, sinkName)
+
+	user.Status.AuthenticationPlugin = authenticationPlugin(user)
+	r.setPassword(user, password)
+	user.Status.CredentialSink = sinkName
+
+	if err := r.Status().Update(ctx, user); err != nil {
+		return fmt.Errorf("restored credentials but failed to record the password: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+)
+
+const (
+	// acceptCredentialsAnnotation opts a namespace in to receiving credential copies. It
+	// lists the namespaces copies are accepted from, separated by commas, or `*` for any.
+	acceptCredentialsAnnotation = "db.breeze.sh/accept-credentials-from"
+	// copies can't have owner references across namespaces, so they're labelled with the
+	// UID of their user instead, and annotated with its namespace and name
+	copyOwnerLabel      = "db.breeze.sh/user-uid"
+	copyOwnerAnnotation = "db.breeze.sh/user"
+)
+
+// secretTargetNamespaceField indexes users by the namespaces they copy their credentials to
+const secretTargetNamespaceField = ".spec.secretTargets.namespace"
+
+// secretTargets returns where the user's credentials are copied to, with defaulted names
+func secretTargets(user *dbv1alpha1.User) []types.NamespacedName {
+	var targets []types.NamespacedName
+	for _, target := range user.Spec.SecretTargets {
+		name := target.Name
+		if name == "" {
+			name = user.Spec.SecretName
+		}
+
+		// the credentials secret itself is never a copy
+		if target.Namespace == user.Namespace && name == user.Spec.SecretName {
+			continue
+		}
+
+		targets = append(targets, types.NamespacedName{Namespace: target.Namespace, Name: name})
+	}
+
+	return targets
+}
+
+// reconcileSecretTargets copies the credentials secret to the user's secret targets and
+// deletes copies that are no longer wanted. Targets in namespaces that haven't opted in,
+// or that hold a secret the user doesn't manage, are reported in a condition.
+func (r *UserReconciler) reconcileSecretTargets(ctx context.Context, user *dbv1alpha1.User) error {
+	sinkName, _, err := r.credentialSink(user)
+
+	if err != nil {
+		return err
+	}
+
+	// credentials kept out of kubernetes aren't copied back into it
+	source := &v1.Secret{}
+	if sinkName == dbv1alpha1.CredentialSinkSecret {
+		err = r.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Spec.SecretName}, source)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var refused []string
+	desired := map[types.NamespacedName]bool{}
+	for _, target := range secretTargets(user) {
+		if sinkName != dbv1alpha1.CredentialSinkSecret {
+			refused = append(refused, fmt.Sprintf("%s (credentials are stored in %s)", target, sinkName))
+			continue
+		}
+
+		accepted, err := r.acceptsCredentials(ctx, target.Namespace, user.Namespace)
+
+		if err != nil {
+			return err
+		}
+
+		if !accepted {
+			refused = append(refused, fmt.Sprintf("%s (namespace hasn't opted in)", target))
+			continue
+		}
+
+		managed, err := r.syncSecretCopy(ctx, user, source, target)
+
+		if err != nil {
+			return err
+		}
+
+		if !managed {
+			refused = append(refused, fmt.Sprintf("%s (secret exists and isn't managed by this user)", target))
+			continue
+		}
+
+		desired[target] = true
+	}
+
+	if err := r.deleteSecretCopies(ctx, user, desired); err != nil {
+		return err
+	}
+
+	if len(refused) > 0 {
+		dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+			Type:    dbv1alpha1.ConditionSecretTargetRefused,
+			Status:  metav1.ConditionTrue,
+			Reason:  "TargetRefused",
+			Message: fmt.Sprintf("credentials weren't copied to: %s", strings.Join(refused, ", ")),
+		})
+	} else {
+		dbv1alpha1.SetCondition(&user.Status.Conditions, dbv1alpha1.Condition{
+			Type:   dbv1alpha1.ConditionSecretTargetRefused,
+			Status: metav1.ConditionFalse,
+		})
+	}
+
+	return nil
+}
+
+// acceptsCredentials checks whether a namespace opted in to copies from another namespace
+func (r *UserReconciler) acceptsCredentials(ctx context.Context, namespace, from string) (bool, error) {
+	if namespace == from {
+		return true, nil
+	}
+
+	ns := &v1.Namespace{}
+	err := r.Get(ctx, types.NamespacedName{Name: namespace}, ns)
+
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, accepted := range strings.Split(ns.Annotations[acceptCredentialsAnnotation], ",") {
+		accepted = strings.TrimSpace(accepted)
+		if accepted == "*" || accepted == from {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// copiedKeys are the keys the operator writes into the credentials secret. Only these are
+// copied, since a secret that was adopted or merged into may hold anything else.
+var copiedKeys = []string{secretKeyUsername, secretKeyPassword, secretKeyTLSCert, secretKeyTLSKey, secretKeyTLSCA}
+
+// copyData returns the keys of the credentials secret that are copied
+func copyData(source *v1.Secret) map[string][]byte {
+	data := map[string][]byte{}
+	for _, key := range copiedKeys {
+		if value, ok := source.Data[key]; ok {
+			data[key] = value
+		}
+	}
+
+	return data
+}
+
+// syncSecretCopy creates or updates one copy, returning false if the secret at the
+// target exists but wasn't created for this user
+func (r *UserReconciler) syncSecretCopy(ctx context.Context, user *dbv1alpha1.User, source *v1.Secret, target types.NamespacedName) (bool, error) {
+	data := copyData(source)
+	secret := &v1.Secret{}
+	err := r.Get(ctx, target, secret)
+
+	if errors.IsNotFound(err) {
+		r.Log.Info("copying credentials", "user", user.Name, "target", target.String())
+
+		return true, r.Create(ctx, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        target.Name,
+				Namespace:   target.Namespace,
+				Labels:      map[string]string{copyOwnerLabel: string(user.UID)},
+				Annotations: map[string]string{copyOwnerAnnotation: user.Namespace + "/" + user.Name},
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: data,
+		})
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if secret.Labels[copyOwnerLabel] != string(user.UID) {
+		return false, nil
+	}
+
+	if reflect.DeepEqual(secret.Data, data) {
+		return true, nil
+	}
+
+	secret.Data = data
+
+	return true, r.Update(ctx, secret)
+}
+
+// deleteSecretCopies deletes the user's copies, except the ones to keep
+func (r *UserReconciler) deleteSecretCopies(ctx context.Context, user *dbv1alpha1.User, keep map[types.NamespacedName]bool) error {
+	copies := &v1.SecretList{}
+	err := r.List(ctx, copies, client.MatchingLabels{copyOwnerLabel: string(user.UID)})
+
+	if err != nil {
+		return err
+	}
+
+	for i := range copies.Items {
+		secret := &copies.Items[i]
+		if keep[types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}] {
+			continue
+		}
+
+		r.Log.Info("deleting credentials copy", "user", user.Name, "secret", secret.Namespace+"/"+secret.Name)
+		if err := r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+)
+
+func TestSecretTargets(t *testing.T) {
+	user := &dbv1alpha1.User{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "payments", Name: "example"},
+		Spec: dbv1alpha1.UserSpec{
+			SecretName: "example-db-credentials",
+			SecretTargets: []dbv1alpha1.SecretTarget{
+				{Namespace: "batch"},
+				{Namespace: "reporting", Name: "payments-db"},
+				// the credentials secret itself
+				{Namespace: "payments"},
+				{Namespace: "payments", Name: "example-db-copy"},
+			},
+		},
+	}
+
+	assert.Equal(t, []types.NamespacedName{
+		{Namespace: "batch", Name: "example-db-credentials"},
+		{Namespace: "reporting", Name: "payments-db"},
+		{Namespace: "payments", Name: "example-db-copy"},
+	}, secretTargets(user))
+
+	assert.Empty(t, secretTargets(&dbv1alpha1.User{}))
+}
+
+func TestCopyData(t *testing.T) {
+	source := &v1.Secret{Data: map[string][]byte{
+		secretKeyUsername: []byte("payments_example"),
+		secretKeyPassword: []byte("secret"),
+		secretKeyTLSCert:  []byte("cert"),
+		"api-token":       []byte("kept in the source namespace"),
+	}}
+
+	assert.Equal(t, map[string][]byte{
+		secretKeyUsername: []byte("payments_example"),
+		secretKeyPassword: []byte("secret"),
+		secretKeyTLSCert:  []byte("cert"),
+	}, copyData(source))
+}
@@ -0,0 +1,177 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/virtualops/sql-operator/certs"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+)
+
+// keys the client certificate is stored under in the credentials secret
+const (
+	secretKeyTLSCert = "DB_TLS_CERT"
+	secretKeyTLSKey  = "DB_TLS_KEY"
+	secretKeyTLSCA   = "DB_TLS_CA"
+)
+
+const (
+	defaultCertificateValidity    = 90 * 24 * time.Hour
+	defaultCertificateRenewBefore = 30 * 24 * time.Hour
+)
+
+// reconcileTLS applies the user's `REQUIRE` option to every account and, in subject
+// mode, makes sure the credentials secret has a valid client certificate. It returns
+// how long until the certificate is due for renewal, or 0 if there is none.
+func (r *UserReconciler) reconcileTLS(ctx context.Context, user *dbv1alpha1.User, hosts []string) (time.Duration, error) {
+	var ca *certs.CA
+	if user.Spec.TLS != nil && user.Spec.TLS.Require == dbv1alpha1.TLSRequireSubject {
+		var err error
+		if ca, err = r.loadCA(ctx, user); err != nil {
+			return 0, err
+		}
+	}
+
+	requirement := tlsRequirement(user.Spec.TLS, r.username(user), ca)
+	applied := user.Status.TLSRequire
+	if applied == "" {
+		applied = "REQUIRE NONE"
+	}
+
+	if requirement != applied {
+		for _, host := range hosts {
+			if err := r.alterAccount(ctx, account{Username: r.username(user), Host: host}, requirement); err != nil {
+				return 0, err
+			}
+		}
+
+		r.Log.Info("changed TLS requirement", "user", r.username(user), "require", requirement)
+		user.Status.TLSRequire = requirement
+	}
+
+	if ca == nil {
+		user.Status.CertificateExpiresAt = nil
+		return 0, nil
+	}
+
+	return r.reconcileCertificate(ctx, user, ca)
+}
+
+// reconcileCertificate issues a new client certificate if the credentials have none, if it no longer
+// matches the username or CA, or if it's about to expire
+func (r *UserReconciler) reconcileCertificate(ctx context.Context, user *dbv1alpha1.User, ca *certs.CA) (time.Duration, error) {
+	validity, renewBefore := certificateLifetime(user.Spec.TLS)
+
+	data, err := r.readCredentials(ctx, user)
+
+	if err != nil {
+		return 0, err
+	}
+
+	certificate, err := certs.ParseCertificate(data[secretKeyTLSCert])
+
+	if err != nil ||
+		certificate.Subject.CommonName != r.username(user) ||
+		certs.OneLine(certificate.Issuer) != certs.OneLine(ca.Certificate.Subject) ||
+		time.Until(certificate.NotAfter) < renewBefore {
+		certPEM, keyPEM, err := ca.Issue(r.username(user), validity)
+
+		if err != nil {
+			return 0, err
+		}
+
+		err = r.writeCredentials(ctx, user, map[string]string{
+			secretKeyTLSCert: string(certPEM),
+			secretKeyTLSKey:  string(keyPEM),
+			secretKeyTLSCA:   string(ca.CertificatePEM),
+		})
+
+		if err != nil {
+			return 0, err
+		}
+
+		if certificate, err = certs.ParseCertificate(certPEM); err != nil {
+			return 0, err
+		}
+
+		r.Log.Info("issued client certificate", "user", r.username(user), "expires", certificate.NotAfter)
+	}
+
+	expiresAt := metav1.NewTime(certificate.NotAfter)
+	user.Status.CertificateExpiresAt = &expiresAt
+
+	return time.Until(certificate.NotAfter) - renewBefore, nil
+}
+
+// loadCA reads the CA that signs the user's client certificates
+func (r *UserReconciler) loadCA(ctx context.Context, user *dbv1alpha1.User) (*certs.CA, error) {
+	if user.Spec.TLS.CASecretName == "" {
+		return nil, fmt.Errorf("tls.caSecretName is required when requiring a certificate subject")
+	}
+
+	secret := &v1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Spec.TLS.CASecretName}, secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return certs.LoadCA(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+}
+
+// tlsRequirement renders the `REQUIRE` option for a TLS spec
+func tlsRequirement(spec *dbv1alpha1.TLSSpec, username string, ca *certs.CA) string {
+	if spec == nil {
+		return "REQUIRE NONE"
+	}
+
+	switch spec.Require {
+	case dbv1alpha1.TLSRequireX509:
+		return "REQUIRE X509"
+	case dbv1alpha1.TLSRequireSubject:
+		subject := fmt.Sprintf("/CN=%s", username)
+		return fmt.Sprintf("REQUIRE SUBJECT %s AND ISSUER %s", sqlString(subject), sqlString(certs.OneLine(ca.Certificate.Subject)))
+	default:
+		return "REQUIRE SSL"
+	}
+}
+
+// certificateLifetime returns how long client certificates are valid and when they're renewed,
+// making sure a certificate isn't due for renewal as soon as it's issued
+func certificateLifetime(spec *dbv1alpha1.TLSSpec) (time.Duration, time.Duration) {
+	validity, renewBefore := defaultCertificateValidity, defaultCertificateRenewBefore
+	if spec.ValidityDays > 0 {
+		validity = time.Duration(spec.ValidityDays) * 24 * time.Hour
+	}
+
+	if spec.RenewBeforeDays > 0 {
+		renewBefore = time.Duration(spec.RenewBeforeDays) * 24 * time.Hour
+	}
+
+	if renewBefore >= validity {
+		renewBefore = validity / 3
+	}
+
+	return validity, renewBefore
+}
@@ -0,0 +1,41 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+	"github.com/virtualops/sql-operator/certs"
+)
+
+func TestTLSRequirement(t *testing.T) {
+	ca := &certs.CA{Certificate: &x509.Certificate{Subject: pkix.Name{Organization: []string{"O'Reilly"}, CommonName: "ops"}}}
+
+	for expected, spec := range map[string]*dbv1alpha1.TLSSpec{
+		"REQUIRE NONE": nil,
+		"REQUIRE SSL":  {Require: dbv1alpha1.TLSRequireSSL},
+		"REQUIRE X509": {Require: dbv1alpha1.TLSRequireX509},
+		`REQUIRE SUBJECT '/CN=o\'neil' AND ISSUER '/O=O\'Reilly/CN=ops'`: {Require: dbv1alpha1.TLSRequireSubject},
+	} {
+		assert.Equal(t, expected, tlsRequirement(spec, "o'neil", ca))
+	}
+}
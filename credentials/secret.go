@@ -0,0 +1,96 @@
+package credentials
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Secrets stores credentials in Kubernetes secrets. Secrets it creates are controlled
+// by their owner, so they're garbage collected along with it.
+type Secrets struct {
+	Client client.Client
+	Scheme *runtime.Scheme
+}
+
+func (s *Secrets) Read(ctx context.Context, location Location) (map[string][]byte, error) {
+	secret := &v1.Secret{}
+	err := s.Client.Get(ctx, types.NamespacedName{Namespace: location.Namespace, Name: location.Name}, secret)
+
+	if errors.IsNotFound(err) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return secret.Data, nil
+}
+
+func (s *Secrets) Write(ctx context.Context, location Location, data map[string][]byte) error {
+	secret := &v1.Secret{}
+	err := s.Client.Get(ctx, types.NamespacedName{Namespace: location.Namespace, Name: location.Name}, secret)
+
+	if errors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      location.Name,
+				Namespace: location.Namespace,
+			},
+			Data: data,
+		}
+
+		if location.Owner != nil {
+			if err := controllerutil.SetControllerReference(location.Owner, secret, s.Scheme); err != nil {
+				return err
+			}
+		}
+
+		return s.Client.Create(ctx, secret)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	merged, changed := merge(secret.Data, data)
+	if !changed {
+		return nil
+	}
+
+	secret.Data = merged
+
+	return s.Client.Update(ctx, secret)
+}
+
+// Delete only deletes secrets controlled by the owner, leaving secrets the
+// credentials were merged into alone
+func (s *Secrets) Delete(ctx context.Context, location Location) error {
+	secret := &v1.Secret{}
+	err := s.Client.Get(ctx, types.NamespacedName{Namespace: location.Namespace, Name: location.Name}, secret)
+
+	if errors.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if location.Owner == nil || !metav1.IsControlledBy(secret, location.Owner) {
+		return nil
+	}
+
+	if err := s.Client.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ErrNotFound is returned by Read when no credentials are stored at a location
+var ErrNotFound = errors.New("credentials not found")
+
+// Owner is the object stored credentials belong to
+type Owner interface {
+	metav1.Object
+	runtime.Object
+}
+
+// Location identifies where one set of credentials is stored
+type Location struct {
+	Namespace string
+	Name      string
+	// Owner ties the credentials' lifetime to an object, where the sink supports it
+	Owner Owner
+}
+
+// Sink stores credentials as a set of keys and values, like DB_USERNAME and DB_PASSWORD.
+// Creation, rotation and deletion of credentials all go through it.
+type Sink interface {
+	// Read returns the stored keys, or ErrNotFound
+	Read(ctx context.Context, location Location) (map[string][]byte, error)
+	// Write stores the given keys, creating the credentials if needed and
+	// keeping any other keys that are already stored
+	Write(ctx context.Context, location Location, data map[string][]byte) error
+	// Delete removes the credentials. Deleting missing credentials is not an error.
+	Delete(ctx context.Context, location Location) error
+}
+
+// merge returns stored with data written over it, and whether that changed anything
+func merge(stored, data map[string][]byte) (map[string][]byte, bool) {
+	merged := map[string][]byte{}
+	for key, value := range stored {
+		merged[key] = value
+	}
+
+	changed := false
+	for key, value := range data {
+		if current, ok := merged[key]; !ok || string(current) != string(value) {
+			merged[key] = value
+			changed = true
+		}
+	}
+
+	return merged, changed
+}
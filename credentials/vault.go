@@ -0,0 +1,166 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Vault stores credentials in a HashiCorp Vault KV version 2 secrets engine, at
+// `<Mount>/data/<Prefix>/<namespace>/<name>`. Writes use check-and-set, so
+// concurrent changes to the same credentials fail rather than overwrite each other.
+type Vault struct {
+	// Address is the Vault server, e.g. `https://vault.example.com:8200`
+	Address string
+	Token   string
+	// Mount is the path the KV engine is mounted at, usually `secret`
+	Mount  string
+	Prefix string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// VaultError is returned for error responses from Vault
+type VaultError struct {
+	StatusCode int      `json:"-"`
+	Errors     []string `json:"errors"`
+}
+
+func (e *VaultError) Error() string {
+	return fmt.Sprintf("vault responded %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+type kvData struct {
+	Data     map[string]string `json:"data"`
+	Metadata struct {
+		Version int `json:"version"`
+	} `json:"metadata"`
+}
+
+func (v *Vault) Read(ctx context.Context, location Location) (map[string][]byte, error) {
+	data, _, err := v.read(ctx, location)
+
+	return data, err
+}
+
+// read returns the stored keys along with their version, for check-and-set. When the latest
+// version was deleted, it returns ErrNotFound along with that version.
+func (v *Vault) read(ctx context.Context, location Location) (map[string][]byte, int, error) {
+	var response struct {
+		Data kvData `json:"data"`
+	}
+
+	if err := v.do(ctx, http.MethodGet, v.url("data", location), nil, &response); err != nil {
+		return nil, response.Data.Metadata.Version, err
+	}
+
+	data := map[string][]byte{}
+	for key, value := range response.Data.Data {
+		data[key] = []byte(value)
+	}
+
+	return data, response.Data.Metadata.Version, nil
+}
+
+func (v *Vault) Write(ctx context.Context, location Location, data map[string][]byte) error {
+	stored, version, err := v.read(ctx, location)
+
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	merged, changed := merge(stored, data)
+	if !changed && err == nil {
+		return nil
+	}
+
+	values := map[string]string{}
+	for key, value := range merged {
+		values[key] = string(value)
+	}
+
+	// a version of 0 only allows the write if the credentials don't exist yet, otherwise
+	// it's the one that was read, even if it was deleted since
+	request := map[string]interface{}{
+		"options": map[string]int{"cas": version},
+		"data":    values,
+	}
+
+	return v.do(ctx, http.MethodPost, v.url("data", location), request, nil)
+}
+
+// Delete removes every version of the credentials, along with their metadata
+func (v *Vault) Delete(ctx context.Context, location Location) error {
+	err := v.do(ctx, http.MethodDelete, v.url("metadata", location), nil, nil)
+
+	if err == ErrNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func (v *Vault) url(kind string, location Location) string {
+	return strings.TrimRight(v.Address, "/") + "/" + path.Join("v1", v.Mount, kind, v.Prefix, location.Namespace, location.Name)
+}
+
+func (v *Vault) do(ctx context.Context, method, url string, body interface{}, into interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+
+	request = request.WithContext(ctx)
+	request.Header.Set("X-Vault-Token", v.Token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		// a deleted latest version is still described, with the version check-and-set expects
+		if into != nil {
+			_ = json.NewDecoder(response.Body).Decode(into)
+		}
+
+		return ErrNotFound
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		vaultErr := &VaultError{StatusCode: response.StatusCode}
+		_ = json.NewDecoder(response.Body).Decode(vaultErr)
+
+		return vaultErr
+	}
+
+	if into == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(into)
+}
@@ -0,0 +1,198 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVault implements the parts of the KV v2 API the sink uses
+type fakeVault struct {
+	sync.Mutex
+	secrets  map[string]map[string]string
+	versions map[string]int
+	// deleted holds the keys whose latest version was deleted, keeping its metadata
+	deleted map[string]bool
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *Vault) {
+	fake := &fakeVault{secrets: map[string]map[string]string{}, versions: map[string]int{}, deleted: map[string]bool{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return fake, &Vault{Address: server.URL, Token: "token", Mount: "secret", Prefix: "sql-operator"}
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != "token" {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+		data, ok := f.secrets[key]
+		if f.deleted[key] {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     nil,
+					"metadata": map[string]interface{}{"version": f.versions[key], "deletion_time": "2020-09-01T12:00:00Z"},
+				},
+			})
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {}})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     data,
+				"metadata": map[string]int{"version": f.versions[key]},
+			},
+		})
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+		var request struct {
+			Options struct {
+				CAS *int `json:"cas"`
+			} `json:"options"`
+			Data map[string]string `json:"data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Options.CAS != nil && *request.Options.CAS != f.versions[key] {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"check-and-set parameter did not match the current version"}})
+			return
+		}
+
+		f.secrets[key] = request.Data
+		f.versions[key]++
+		delete(f.deleted, key)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"version": f.versions[key]}})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		f.deleted[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")] = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/")
+		delete(f.secrets, key)
+		delete(f.versions, key)
+		delete(f.deleted, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+var location = Location{Namespace: "payments", Name: "example-db-credentials"}
+
+func TestVaultReadMissing(t *testing.T) {
+	_, vault := newFakeVault(t)
+
+	_, err := vault.Read(context.Background(), location)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestVaultWriteMerges(t *testing.T) {
+	fake, vault := newFakeVault(t)
+	ctx := context.Background()
+
+	assert.NoError(t, vault.Write(ctx, location, map[string][]byte{"DB_USERNAME": []byte("example"), "DB_PASSWORD": []byte("one")}))
+	assert.NoError(t, vault.Write(ctx, location, map[string][]byte{"DB_PASSWORD": []byte("two")}))
+
+	data, err := vault.Read(ctx, location)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"DB_USERNAME": []byte("example"), "DB_PASSWORD": []byte("two")}, data)
+	assert.Equal(t, 2, fake.versions["sql-operator/payments/example-db-credentials"])
+
+	// unchanged keys don't create a new version
+	assert.NoError(t, vault.Write(ctx, location, map[string][]byte{"DB_PASSWORD": []byte("two")}))
+	assert.Equal(t, 2, fake.versions["sql-operator/payments/example-db-credentials"])
+}
+
+func TestVaultWriteDetectsConcurrentChanges(t *testing.T) {
+	fake, vault := newFakeVault(t)
+	ctx := context.Background()
+
+	assert.NoError(t, vault.Write(ctx, location, map[string][]byte{"DB_PASSWORD": []byte("one")}))
+
+	// another writer bumps the version between our read and write
+	vault.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodPost {
+			fake.Lock()
+			fake.versions["sql-operator/payments/example-db-credentials"]++
+			fake.Unlock()
+		}
+
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	err := vault.Write(ctx, location, map[string][]byte{"DB_PASSWORD": []byte("two")})
+	assert.IsType(t, &VaultError{}, err)
+	assert.Contains(t, err.Error(), "check-and-set")
+}
+
+func TestVaultWriteRecreatesDeletedVersion(t *testing.T) {
+	fake, vault := newFakeVault(t)
+	ctx := context.Background()
+
+	assert.NoError(t, vault.Write(ctx, location, map[string][]byte{"DB_PASSWORD": []byte("one")}))
+
+	// deleting the latest version keeps the metadata, so check-and-set still expects it
+	request, err := http.NewRequest(http.MethodDelete, vault.url("data", location), nil)
+	assert.NoError(t, err)
+	request.Header.Set("X-Vault-Token", "token")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+
+	_, err = vault.Read(ctx, location)
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.NoError(t, vault.Write(ctx, location, map[string][]byte{"DB_PASSWORD": []byte("two")}))
+
+	data, err := vault.Read(ctx, location)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"DB_PASSWORD": []byte("two")}, data)
+	assert.Equal(t, 2, fake.versions["sql-operator/payments/example-db-credentials"])
+}
+
+func TestVaultDelete(t *testing.T) {
+	_, vault := newFakeVault(t)
+	ctx := context.Background()
+
+	assert.NoError(t, vault.Write(ctx, location, map[string][]byte{"DB_PASSWORD": []byte("one")}))
+	assert.NoError(t, vault.Delete(ctx, location))
+
+	_, err := vault.Read(ctx, location)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestVaultErrors(t *testing.T) {
+	_, vault := newFakeVault(t)
+	vault.Token = "wrong"
+
+	_, err := vault.Read(context.Background(), location)
+	assert.EqualError(t, err, "vault responded 403: permission denied")
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -1,6 +1,7 @@
 package grants
 
 import (
+	"fmt"
 	"github.com/virtualops/sql-operator/api/v1alpha1"
 	"reflect"
 )
@@ -8,13 +9,24 @@ import (
 type GrantDiff struct {
 	Revoke []v1alpha1.GrantSpec
 	Grant  []v1alpha1.GrantSpec
+	// PartialRevoke holds the schema-level revokes that implement exclusions.
+	// They must be applied after Grant, since they restrict a global privilege.
+	PartialRevoke []v1alpha1.GrantSpec
+}
+
+// ExclusionTarget is the grant target a partial revoke for the schema applies to. Exclusions
+// require partial_revokes, under which schema names are literal, so it isn't escaped and the
+// specs using it are marked as patterns to be sent as-is.
+func ExclusionTarget(schema string) string {
+	return fmt.Sprintf("`%s`.*", schema)
 }
 
 // SegmentByTarget will split the current and new grant specs into three slices
 // containing [current - intersection] [intersection] [new - intersection]
 func SegmentByTarget(currentGrants, newGrants []v1alpha1.GrantSpec) ([]v1alpha1.GrantSpec, [][2]v1alpha1.GrantSpec, []v1alpha1.GrantSpec) {
-	// we create a map from the hash identifier (grant target) to a slice of
-	// two indices – the index in remove, and the index in new
+	// we create a map from the hash identifier (rendered grant target) to a slice of
+	// two indices – the index in remove, and the index in new. Using the rendered
+	// target means a literal `my_app.*` and a pattern `my\_app.*` are the same target.
 	hashMap := map[string]int{}
 
 	// because we're modifying slices directly, we need to make local copies to not modify the source slices
@@ -25,7 +37,7 @@ func SegmentByTarget(currentGrants, newGrants []v1alpha1.GrantSpec) ([]v1alpha1.
 	copy(new, newGrants)
 
 	for i, spec := range remove {
-		hashMap[spec.Target] = i
+		hashMap[RenderTarget(spec)] = i
 	}
 
 	intersection := map[string][2]v1alpha1.GrantSpec{}
@@ -34,7 +46,7 @@ func SegmentByTarget(currentGrants, newGrants []v1alpha1.GrantSpec) ([]v1alpha1.
 	for target, _ := range hashMap {
 		remove := true
 		for _, spec := range new {
-			if target == spec.Target {
+			if target == RenderTarget(spec) {
 				remove = false
 				break
 			}
@@ -52,8 +64,8 @@ func SegmentByTarget(currentGrants, newGrants []v1alpha1.GrantSpec) ([]v1alpha1.
 		}
 		spec := new[i]
 
-		if _, ok := hashMap[spec.Target]; ok {
-			intersection[spec.Target] = [2]v1alpha1.GrantSpec{{}, spec}
+		if _, ok := hashMap[RenderTarget(spec)]; ok {
+			intersection[RenderTarget(spec)] = [2]v1alpha1.GrantSpec{{}, spec}
 			copy(new[i:], new[i+1:])               // Shift a[i+1:] left one index.
 			//new[len(new)-1] = v1alpha1.GrantSpec{} // Erase last element (write zero value).
 			new = new[:len(new)-1]                 // Truncate slice.
@@ -66,9 +78,9 @@ func SegmentByTarget(currentGrants, newGrants []v1alpha1.GrantSpec) ([]v1alpha1.
 
 	for _, i := range hashMap {
 		record := remove[i]
-		records := intersection[record.Target]
+		records := intersection[RenderTarget(record)]
 		records[0] = record
-		intersection[record.Target] = records
+		intersection[RenderTarget(record)] = records
 
 		// set a zero value for all to remove
 		remove[i] = v1alpha1.GrantSpec{}
@@ -113,7 +125,8 @@ func DiffPrivileges(curr, new v1alpha1.GrantSpec) GrantDiff {
 	}
 
 	diff.Revoke  = []v1alpha1.GrantSpec{{
-		Target: curr.Target,
+		Target:  curr.Target,
+		Pattern: curr.Pattern,
 	}}
 
 	for _, privilege := range curr.Privileges {
@@ -131,7 +144,8 @@ func DiffPrivileges(curr, new v1alpha1.GrantSpec) GrantDiff {
 	}
 
 	diff.Grant = []v1alpha1.GrantSpec{{
-		Target: curr.Target,
+		Target:  curr.Target,
+		Pattern: curr.Pattern,
 	}}
 
 	for _, privilege := range new.Privileges {
@@ -151,6 +165,46 @@ func DiffPrivileges(curr, new v1alpha1.GrantSpec) GrantDiff {
 	return diff
 }
 
+// DiffExclusions generates the partial revokes needed to move from the current to the new
+// exclusions on a target. Newly excluded schemas are restricted for every privilege, while
+// schemas that were already excluded only need restricting for newly granted privileges.
+// Exclusions that are dropped are lifted by granting the retained privileges on the schema.
+func DiffExclusions(curr, new v1alpha1.GrantSpec) GrantDiff {
+	diff := GrantDiff{}
+
+	for _, schema := range new.Exclude {
+		privileges := new.Privileges
+		if containsString(curr.Exclude, schema) {
+			privileges = subtractPrivileges(new.Privileges, curr.Privileges)
+		}
+
+		if len(privileges) > 0 {
+			diff.PartialRevoke = append(diff.PartialRevoke, v1alpha1.GrantSpec{
+				Target:     ExclusionTarget(schema),
+				Privileges: privileges,
+				Pattern:    true,
+			})
+		}
+	}
+
+	for _, schema := range curr.Exclude {
+		if containsString(new.Exclude, schema) {
+			continue
+		}
+
+		privileges := intersectPrivileges(curr.Privileges, new.Privileges)
+		if len(privileges) > 0 {
+			diff.Grant = append(diff.Grant, v1alpha1.GrantSpec{
+				Target:     ExclusionTarget(schema),
+				Privileges: privileges,
+				Pattern:    true,
+			})
+		}
+	}
+
+	return diff
+}
+
 func GenerateExecutionPlan(current, new []v1alpha1.GrantSpec) GrantDiff {
 	diff := GrantDiff{}
 
@@ -171,7 +225,86 @@ func GenerateExecutionPlan(current, new []v1alpha1.GrantSpec) GrantDiff {
 		if len(innerDiff.Revoke[0].Privileges) > 0 {
 			diff.Revoke = append(diff.Revoke, innerDiff.Revoke[0])
 		}
+
+		exclusionDiff := DiffExclusions(intersection[0], intersection[1])
+		diff.Grant = append(diff.Grant, exclusionDiff.Grant...)
+		diff.PartialRevoke = append(diff.PartialRevoke, exclusionDiff.PartialRevoke...)
+	}
+	// 5. Exclusions on added targets restrict everything that was granted
+	for _, spec := range add {
+		diff.PartialRevoke = append(diff.PartialRevoke, DiffExclusions(v1alpha1.GrantSpec{}, spec).PartialRevoke...)
 	}
 
 	return diff
 }
+
+// LegacyGrants returns the schema-level grants in current as they were applied before schema
+// names were escaped: as patterns on the unescaped name, which match other schemas too. Passed
+// to Held.Current after the others, the ones still held end up in the plan's revokes.
+func LegacyGrants(current []v1alpha1.GrantSpec) []v1alpha1.GrantSpec {
+	var legacy []v1alpha1.GrantSpec
+	for _, spec := range current {
+		schema, object, ok := splitTarget(spec.Target)
+		if spec.Pattern || !ok || schema == "*" || object != "*" || EscapeSchema(schema) == schema {
+			continue
+		}
+
+		legacy = append(legacy, v1alpha1.GrantSpec{
+			Target:     spec.Target,
+			Privileges: spec.Privileges,
+			Pattern:    true,
+		})
+	}
+
+	return legacy
+}
+
+func isAllPrivileges(privileges []string) bool {
+	return len(privileges) == 1 && privileges[0] == "*"
+}
+
+// subtractPrivileges returns the privileges in a that aren't in b
+func subtractPrivileges(a, b []string) []string {
+	if isAllPrivileges(b) {
+		return nil
+	}
+
+	var output []string
+	for _, privilege := range a {
+		if !containsString(b, privilege) {
+			output = append(output, privilege)
+		}
+	}
+
+	return output
+}
+
+// intersectPrivileges returns the privileges in both a and b
+func intersectPrivileges(a, b []string) []string {
+	if isAllPrivileges(a) {
+		return b
+	}
+
+	if isAllPrivileges(b) {
+		return a
+	}
+
+	var output []string
+	for _, privilege := range a {
+		if containsString(b, privilege) {
+			output = append(output, privilege)
+		}
+	}
+
+	return output
+}
+
+func containsString(input []string, search string) bool {
+	for _, i := range input {
+		if i == search {
+			return true
+		}
+	}
+
+	return false
+}
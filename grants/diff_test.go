@@ -102,3 +102,82 @@ func TestGenerateExecutionPlanWithoutCurrentGrants(t *testing.T) {
 
 	assert.Equal(t, diff.Grant, newGrants)
 }
+
+func TestGenerateExecutionPlanWithExclusions(t *testing.T) {
+	currentGrants := []v1alpha1.GrantSpec{
+		{
+			Target:     "*.*",
+			Privileges: []string{"SELECT"},
+			Exclude:    []string{"payroll", "hr"},
+		},
+	}
+	newGrants := []v1alpha1.GrantSpec{
+		{
+			Target:     "*.*",
+			Privileges: []string{"SELECT", "SHOW VIEW"},
+			Exclude:    []string{"payroll", "audit"},
+		},
+	}
+
+	diff := GenerateExecutionPlan(currentGrants, newGrants)
+	assert.Len(t, diff.Revoke, 0)
+	assert.Equal(t, []v1alpha1.GrantSpec{
+		{Target: "*.*", Privileges: []string{"SHOW VIEW"}},
+		{Target: "`hr`.*", Privileges: []string{"SELECT"}, Pattern: true}, // lifting the exclusion
+	}, diff.Grant)
+	assert.Equal(t, []v1alpha1.GrantSpec{
+		{Target: "`payroll`.*", Privileges: []string{"SHOW VIEW"}, Pattern: true}, // only the new privilege needs restricting
+		{Target: "`audit`.*", Privileges: []string{"SELECT", "SHOW VIEW"}, Pattern: true},
+	}, diff.PartialRevoke)
+}
+
+func TestGenerateExecutionPlanWithExclusionsOnNewTarget(t *testing.T) {
+	newGrants := []v1alpha1.GrantSpec{
+		{
+			Target:     "*.*",
+			Privileges: []string{"SELECT"},
+			Exclude:    []string{"payroll"},
+		},
+	}
+
+	diff := GenerateExecutionPlan(nil, newGrants)
+	assert.Equal(t, newGrants, diff.Grant)
+	assert.Equal(t, []v1alpha1.GrantSpec{
+		{Target: "`payroll`.*", Privileges: []string{"SELECT"}, Pattern: true},
+	}, diff.PartialRevoke)
+}
+
+func TestGenerateExecutionPlanRevokesLegacyTargets(t *testing.T) {
+	status := []v1alpha1.GrantSpec{
+		{Target: "my_app.*", Privileges: []string{"SELECT"}},
+		{Target: "reports.*", Privileges: []string{"SELECT"}},
+		{Target: "my_app.orders", Privileges: []string{"UPDATE"}},
+	}
+
+	assert.Equal(t, []v1alpha1.GrantSpec{
+		{Target: "my_app.*", Privileges: []string{"SELECT"}, Pattern: true},
+	}, LegacyGrants(status))
+
+	// applied before the upgrade, on the unescaped schema name
+	var held Held
+	held.Grant("`my_app`.*", "SELECT")
+	held.Grant("`reports`.*", "SELECT")
+	held.Grant("`my_app`.`orders`", "UPDATE")
+
+	current := held.Current(status, status, LegacyGrants(status))
+	diff := GenerateExecutionPlan(current, status)
+
+	assert.Equal(t, []v1alpha1.GrantSpec{{Target: "my_app.*", Privileges: []string{"SELECT"}}}, diff.Grant)
+	assert.Equal(t, []v1alpha1.GrantSpec{{Target: "my_app.*", Privileges: []string{"SELECT"}, Pattern: true}}, diff.Revoke)
+	assert.Equal(t, "`my_app`.*", RenderTarget(diff.Revoke[0]))
+
+	// once migrated, nothing is left to do
+	held = Held{}
+	held.Grant("`my\\_app`.*", "SELECT")
+	held.Grant("`reports`.*", "SELECT")
+	held.Grant("`my_app`.`orders`", "UPDATE")
+
+	diff = GenerateExecutionPlan(held.Current(status, status, LegacyGrants(status)), status)
+	assert.Empty(t, diff.Grant)
+	assert.Empty(t, diff.Revoke)
+}
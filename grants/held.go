@@ -0,0 +1,164 @@
+package grants
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/virtualops/sql-operator/api/v1alpha1"
+)
+
+// Held is what an account holds on the server, by rendered target
+type Held struct {
+	// Privileges are upper case
+	Privileges map[string][]string
+	// Revoked holds the partial revokes that restrict global privileges
+	Revoked map[string][]string
+}
+
+// CatalogTarget renders a schema and table the catalog lists privileges on as a target, the
+// way RenderTarget does. An empty schema is global, and an empty table the whole schema. The
+// catalog keeps names as they were granted, escaped or not, so they are used as-is.
+func CatalogTarget(schema, table string) string {
+	switch {
+	case schema == "":
+		return "*.*"
+	case table == "":
+		return fmt.Sprintf("`%s`.*", schema)
+	default:
+		return fmt.Sprintf("`%s`.`%s`", schema, table)
+	}
+}
+
+// Grant records privileges held on a target. USAGE, which only means the account exists,
+// is left out.
+func (h *Held) Grant(target string, privileges ...string) {
+	if h.Privileges == nil {
+		h.Privileges = map[string][]string{}
+	}
+
+	for _, privilege := range privileges {
+		privilege = strings.ToUpper(strings.TrimSpace(privilege))
+		if privilege == "" || privilege == "USAGE" || containsString(h.Privileges[target], privilege) {
+			continue
+		}
+
+		h.Privileges[target] = append(h.Privileges[target], privilege)
+	}
+}
+
+// Revoke records privileges partially revoked on a target
+func (h *Held) Revoke(target string, privileges ...string) {
+	if h.Revoked == nil {
+		h.Revoked = map[string][]string{}
+	}
+
+	for _, privilege := range privileges {
+		h.Revoked[target] = append(h.Revoked[target], strings.ToUpper(privilege))
+	}
+}
+
+// Current describes the grants held on the targets of the known grants, spelled the way the
+// known grants spell their privileges, so it can be diffed against them. Targets nothing is
+// held on are left out. Since the catalog lists ALL PRIVILEGES one by one, a known `*`
+// counts as held as long as anything is held on its target.
+func (h Held) Current(known ...[]v1alpha1.GrantSpec) []v1alpha1.GrantSpec {
+	var targets []string
+	specs := map[string]v1alpha1.GrantSpec{}
+	spellings := map[string]map[string]string{}
+	excludes := map[string][]string{}
+
+	for _, list := range known {
+		for _, spec := range list {
+			target := RenderTarget(spec)
+			if _, ok := specs[target]; !ok {
+				targets = append(targets, target)
+				specs[target] = spec
+				spellings[target] = map[string]string{}
+			}
+
+			for _, privilege := range spec.Privileges {
+				if _, ok := spellings[target][strings.ToUpper(privilege)]; !ok {
+					spellings[target][strings.ToUpper(privilege)] = privilege
+				}
+			}
+
+			for _, schema := range spec.Exclude {
+				if !containsString(excludes[target], schema) {
+					excludes[target] = append(excludes[target], schema)
+				}
+			}
+		}
+	}
+
+	var current []v1alpha1.GrantSpec
+	for _, target := range targets {
+		held := h.Privileges[target]
+		if len(held) == 0 {
+			continue
+		}
+
+		spelling := spellings[target]
+		var privileges []string
+		if spelling["*"] != "" {
+			privileges = []string{"*"}
+		} else {
+			for _, privilege := range held {
+				if spelled, ok := spelling[privilege]; ok {
+					privilege = spelled
+				}
+				privileges = append(privileges, privilege)
+			}
+		}
+
+		spec := v1alpha1.GrantSpec{
+			Target:     specs[target].Target,
+			Pattern:    specs[target].Pattern,
+			Privileges: privileges,
+		}
+		for _, schema := range excludes[target] {
+			if len(h.Revoked[ExclusionTarget(schema)]) > 0 {
+				spec.Exclude = append(spec.Exclude, schema)
+			}
+		}
+
+		current = append(current, spec)
+	}
+
+	return current
+}
+
+// Equal reports whether two sets of grants give the same privileges on the same targets,
+// regardless of their order and the case of the privileges
+func Equal(a, b []v1alpha1.GrantSpec) bool {
+	normalize := func(specs []v1alpha1.GrantSpec) map[string]string {
+		output := map[string]string{}
+		for _, spec := range specs {
+			var privileges []string
+			for _, privilege := range spec.Privileges {
+				privileges = append(privileges, strings.ToUpper(privilege))
+			}
+			sort.Strings(privileges)
+
+			exclude := append([]string(nil), spec.Exclude...)
+			sort.Strings(exclude)
+
+			output[RenderTarget(spec)] = strings.Join(privileges, ",") + ";" + strings.Join(exclude, ",")
+		}
+
+		return output
+	}
+
+	left, right := normalize(a), normalize(b)
+	if len(left) != len(right) {
+		return false
+	}
+
+	for target, privileges := range left {
+		if right[target] != privileges {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,81 @@
+package grants
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtualops/sql-operator/api/v1alpha1"
+	"testing"
+)
+
+func TestCatalogTarget(t *testing.T) {
+	assert.Equal(t, "*.*", CatalogTarget("", ""))
+	assert.Equal(t, "`my\\_app`.*", CatalogTarget("my\\_app", ""))
+	assert.Equal(t, "`my_app`.`orders`", CatalogTarget("my_app", "orders"))
+}
+
+func TestHeldGrant(t *testing.T) {
+	var held Held
+	held.Grant("*.*", "USAGE")
+	held.Grant("`my\\_app`.*", "select", "INSERT", "SELECT")
+
+	assert.Equal(t, map[string][]string{"`my\\_app`.*": {"SELECT", "INSERT"}}, held.Privileges)
+}
+
+func TestHeldCurrent(t *testing.T) {
+	var held Held
+	held.Grant("`my\\_app`.*", "SELECT", "INSERT")
+	held.Grant("`reports`.*", "SELECT", "INSERT", "UPDATE", "DELETE")
+	held.Grant("*.*", "SELECT", "RELOAD")
+	held.Grant("`unmanaged`.*", "SELECT")
+
+	status := []v1alpha1.GrantSpec{
+		{Target: "my_app.*", Privileges: []string{"select"}},
+		{Target: "removed.*", Privileges: []string{"SELECT"}},
+	}
+	spec := []v1alpha1.GrantSpec{
+		{Target: "my_app.*", Privileges: []string{"select", "update"}},
+		{Target: "reports.*", Privileges: []string{"SELECT", "DELETE"}},
+		{Target: "*.*", Privileges: []string{"*"}},
+	}
+
+	assert.Equal(t, []v1alpha1.GrantSpec{
+		// spelled like the known grants, with the privilege granted outside the operator
+		{Target: "my_app.*", Privileges: []string{"select", "INSERT"}},
+		// privileges granted outside the operator are listed, so they can be revoked
+		{Target: "reports.*", Privileges: []string{"SELECT", "INSERT", "UPDATE", "DELETE"}},
+		// all privileges are listed one by one
+		{Target: "*.*", Privileges: []string{"*"}},
+	}, held.Current(status, spec))
+}
+
+func TestHeldCurrentExclusions(t *testing.T) {
+	var held Held
+	held.Grant("*.*", "SELECT")
+	held.Revoke(ExclusionTarget("payroll"), "SELECT")
+
+	spec := []v1alpha1.GrantSpec{{Target: "*.*", Privileges: []string{"SELECT"}, Exclude: []string{"payroll", "hr"}}}
+
+	assert.Equal(t, []v1alpha1.GrantSpec{
+		{Target: "*.*", Privileges: []string{"SELECT"}, Exclude: []string{"payroll"}},
+	}, held.Current(spec))
+}
+
+func TestEqual(t *testing.T) {
+	a := []v1alpha1.GrantSpec{
+		{Target: "my_app.*", Privileges: []string{"select", "INSERT"}},
+		{Target: "*.*", Privileges: []string{"SELECT"}, Exclude: []string{"payroll"}},
+	}
+
+	assert.True(t, Equal(a, []v1alpha1.GrantSpec{
+		{Target: "*.*", Privileges: []string{"SELECT"}, Exclude: []string{"payroll"}},
+		{Target: "my\\_app.*", Pattern: true, Privileges: []string{"INSERT", "SELECT"}},
+	}))
+	assert.False(t, Equal(a, []v1alpha1.GrantSpec{
+		{Target: "my_app.*", Privileges: []string{"SELECT"}},
+		{Target: "*.*", Privileges: []string{"SELECT"}, Exclude: []string{"payroll"}},
+	}))
+	assert.False(t, Equal(a, []v1alpha1.GrantSpec{
+		{Target: "my_app.*", Privileges: []string{"SELECT", "INSERT"}},
+		{Target: "*.*", Privileges: []string{"SELECT"}},
+	}))
+	assert.True(t, Equal(nil, nil))
+}
@@ -0,0 +1,62 @@
+package grants
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/virtualops/sql-operator/api/v1alpha1"
+)
+
+var wildcardEscaper = strings.NewReplacer("_", `\_`, "%", `\%`)
+
+// EscapeSchema escapes the `_` and `%` wildcards MySQL recognises in schema names
+// in GRANT statements, so that the name only matches itself.
+func EscapeSchema(schema string) string {
+	return wildcardEscaper.Replace(schema)
+}
+
+// RenderTarget renders a grant target the way it is sent to the server, with both parts
+// quoted. Wildcards only apply to schema names in schema-level grants such as `my_app.*`,
+// so only those are escaped, unless the spec is explicitly a pattern.
+func RenderTarget(spec v1alpha1.GrantSpec) string {
+	schema, object, ok := splitTarget(spec.Target)
+	if !ok {
+		return spec.Target
+	}
+
+	if schema != "*" {
+		if !spec.Pattern && object == "*" {
+			schema = EscapeSchema(schema)
+		}
+		schema = fmt.Sprintf("`%s`", schema)
+	}
+
+	if object != "*" {
+		object = fmt.Sprintf("`%s`", object)
+	}
+
+	return schema + "." + object
+}
+
+// splitTarget splits `schema.object` into its unquoted parts, honouring backtick quoting
+func splitTarget(target string) (string, string, bool) {
+	quoted := false
+	for i, c := range target {
+		switch {
+		case c == '`':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			return unquote(target[:i]), unquote(target[i+1:]), true
+		}
+	}
+
+	return "", "", false
+}
+
+func unquote(identifier string) string {
+	if len(identifier) >= 2 && identifier[0] == '`' && identifier[len(identifier)-1] == '`' {
+		return identifier[1 : len(identifier)-1]
+	}
+
+	return identifier
+}
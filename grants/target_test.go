@@ -0,0 +1,57 @@
+package grants
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtualops/sql-operator/api/v1alpha1"
+	"testing"
+)
+
+func TestRenderTarget(t *testing.T) {
+	assert.Equal(t, "*.*", RenderTarget(v1alpha1.GrantSpec{Target: "*.*"}))
+	assert.Equal(t, "`example`.*", RenderTarget(v1alpha1.GrantSpec{Target: "example.*"}))
+	assert.Equal(t, "`my\\_app`.*", RenderTarget(v1alpha1.GrantSpec{Target: "my_app.*"}))
+	assert.Equal(t, "`100%`.`my_table`", RenderTarget(v1alpha1.GrantSpec{Target: "`100%`.my_table"}))
+	assert.Equal(t, "`my_app`.`my_table`", RenderTarget(v1alpha1.GrantSpec{Target: "my_app.my_table"}))
+	assert.Equal(t, "`tenant\\_%`.*", RenderTarget(v1alpha1.GrantSpec{Target: "tenant\\_%.*", Pattern: true}))
+	assert.Equal(t, "`my.app`.*", RenderTarget(v1alpha1.GrantSpec{Target: "`my.app`.*"}))
+}
+
+func TestGenerateExecutionPlanWithPatternTargets(t *testing.T) {
+	currentGrants := []v1alpha1.GrantSpec{
+		{
+			Target:     "my_app.*",
+			Privileges: []string{"SELECT"},
+		},
+		{
+			Target:     "tenant_%.*",
+			Privileges: []string{"SELECT"},
+			Pattern:    true,
+		},
+	}
+	newGrants := []v1alpha1.GrantSpec{
+		{
+			// the same target on the server as the literal `my_app.*`
+			Target:     "my\\_app.*",
+			Privileges: []string{"SELECT"},
+			Pattern:    true,
+		},
+		{
+			// a different target on the server than the `tenant_%` pattern
+			Target:     "tenant_%.*",
+			Privileges: []string{"SELECT"},
+		},
+	}
+
+	diff := GenerateExecutionPlan(currentGrants, newGrants)
+	assert.Equal(t, []v1alpha1.GrantSpec{newGrants[1]}, diff.Grant)
+	assert.Equal(t, []v1alpha1.GrantSpec{currentGrants[1]}, diff.Revoke)
+}
+
+func TestExclusionTargetsAreLiteral(t *testing.T) {
+	diff := GenerateExecutionPlan(nil, []v1alpha1.GrantSpec{
+		{Target: "*.*", Privileges: []string{"SELECT"}, Exclude: []string{"pay_roll"}},
+	})
+
+	// partial revokes take schema names literally, so they aren't escaped
+	assert.Equal(t, "`pay_roll`.*", RenderTarget(diff.PartialRevoke[0]))
+}
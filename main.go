@@ -17,21 +17,38 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+	"github.com/virtualops/sql-operator/audit"
 	"github.com/virtualops/sql-operator/controllers"
+	"github.com/virtualops/sql-operator/credentials"
+	"github.com/virtualops/sql-operator/metrics"
+	"github.com/virtualops/sql-operator/ownership"
+	"github.com/virtualops/sql-operator/password"
+	"github.com/virtualops/sql-operator/server"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -50,55 +67,318 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var enableWebhooks bool
+	var credentialSink string
+	var clusterID string
+	var bookkeepingSchema string
+	var vault credentials.Vault
+	var resync controllers.Resync
+	var auditLog string
+	var auditEvents bool
+	var reconcileTimeout, readTimeout, writeTimeout, lockWaitTimeout time.Duration
+	var userConcurrency, databaseConcurrency, maxConcurrentWrites int
+	var writesPerSecond float64
+	var probeAddr string
+	passwordGenerator := password.DefaultPolicy
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Enable the validating webhooks. They need serving certificates, see config/default.")
+	flag.IntVar(&passwordGenerator.Length, "password-length", passwordGenerator.Length, "The length of generated passwords.")
+	flag.IntVar(&passwordGenerator.MinLowercase, "password-min-lowercase", passwordGenerator.MinLowercase,
+		"The minimum number of lowercase letters in generated passwords.")
+	flag.IntVar(&passwordGenerator.MinUppercase, "password-min-uppercase", passwordGenerator.MinUppercase,
+		"The minimum number of uppercase letters in generated passwords.")
+	flag.IntVar(&passwordGenerator.MinDigits, "password-min-digits", passwordGenerator.MinDigits,
+		"The minimum number of digits in generated passwords.")
+	flag.IntVar(&passwordGenerator.MinSpecial, "password-min-special", passwordGenerator.MinSpecial,
+		"The minimum number of special characters in generated passwords.")
+	flag.StringVar(&passwordGenerator.Exclude, "password-exclude", passwordGenerator.Exclude,
+		"Characters that never appear in generated passwords.")
+	flag.StringVar(&credentialSink, "credential-sink", string(dbv1alpha1.CredentialSinkSecret),
+		"Where credentials are stored for users that don't choose, Secret or Vault.")
+	flag.StringVar(&vault.Address, "vault-address", os.Getenv("VAULT_ADDR"),
+		"The Vault server credentials can be stored in. The token is read from VAULT_TOKEN.")
+	flag.StringVar(&vault.Mount, "vault-mount", "secret", "The path the Vault KV version 2 engine is mounted at.")
+	flag.StringVar(&vault.Prefix, "vault-prefix", "sql-operator", "The path under the Vault mount credentials are stored at.")
+	flag.StringVar(&clusterID, "cluster-id", "",
+		"Identifies this cluster in the ownership markers on the server. Defaults to the UID of the kube-system namespace.")
+	flag.StringVar(&bookkeepingSchema, "bookkeeping-schema", "sql_operator",
+		"The schema holding the table that records which Database manages each schema.")
+	flag.DurationVar(&resync.Interval, "resync-interval", 10*time.Minute,
+		"How often objects are compared against the server even if they don't change. 0 disables resyncing.")
+	flag.Float64Var(&resync.Jitter, "resync-jitter", 0.2,
+		"Spreads resyncs out by up to this fraction of the resync interval.")
+	flag.StringVar(&auditLog, "audit-log", "",
+		"Where to write a JSON line for every statement run on the server: a file, or - for stdout. Empty disables the audit log.")
+	flag.BoolVar(&auditEvents, "audit-events", false,
+		"Also record every statement that changes something as an event on the resource it was run for.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 5*time.Minute,
+		"How long a single reconcile may take. 0 leaves it unbounded.")
+	flag.DurationVar(&readTimeout, "read-timeout", 10*time.Second,
+		"How long a query may take. 0 leaves it to the reconcile timeout.")
+	flag.DurationVar(&writeTimeout, "write-timeout", time.Minute,
+		"How long a statement that changes something may take. 0 leaves it to the reconcile timeout.")
+	flag.DurationVar(&lockWaitTimeout, "lock-wait-timeout", 30*time.Second,
+		"The session lock_wait_timeout, so DDL waiting on a metadata lock fails instead of blocking. Keep it below --write-timeout. 0 keeps the server's default.")
+	flag.IntVar(&userConcurrency, "user-concurrency", 1, "How many Users are reconciled at once.")
+	flag.IntVar(&databaseConcurrency, "database-concurrency", 1, "How many Databases are reconciled at once.")
+	flag.IntVar(&maxConcurrentWrites, "max-concurrent-writes", 4,
+		"How many statements that change something may run on the server at once. 0 is unlimited.")
+	flag.Float64Var(&writesPerSecond, "writes-per-second", 20,
+		"How many statements that change something may start on the server each second. 0 is unlimited.")
+	flag.StringVar(&probeAddr, "health-probe-addr", ":8081", "The address the /healthz and /readyz probes bind to.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	// reconciles are cancelled when the manager is asked to stop
+	stop := ctrl.SetupSignalHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "406abbc3.breeze.sh",
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: probeAddr,
+		Port:                   9443,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "406abbc3.breeze.sh",
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	db, err := sqlx.Connect("mysql", os.Getenv("DB_DSN"))
+	config, err := mysql.ParseDSN(os.Getenv("DB_DSN"))
+
+	if err != nil {
+		setupLog.Error(err, "invalid DB_DSN")
+		os.Exit(1)
+	}
+
+	// the key guards the password hashes on the status, and changing it re-applies every
+	// password, so it's given explicitly rather than derived from something that changes
+	passwordHashKey := os.Getenv("PASSWORD_HASH_KEY")
+	if passwordHashKey == "" {
+		setupLog.Error(nil, "PASSWORD_HASH_KEY is not set")
+		os.Exit(1)
+	}
+
+	// DDL waiting on a metadata lock fails with a lock wait timeout, which is retried with
+	// backoff, instead of holding a worker until the write timeout abandons it on the server
+	if _, set := config.Params["lock_wait_timeout"]; !set && lockWaitTimeout > 0 {
+		if config.Params == nil {
+			config.Params = map[string]string{}
+		}
+		config.Params["lock_wait_timeout"] = strconv.Itoa(int(lockWaitTimeout.Seconds()))
+	}
+
+	// the privileges of an account are concatenated into the catalog query, so they mustn't be
+	// cut off at the default 1024 bytes
+	if _, set := config.Params["group_concat_max_len"]; !set {
+		if config.Params == nil {
+			config.Params = map[string]string{}
+		}
+		config.Params["group_concat_max_len"] = "1048576"
+	}
+
+	// the server isn't contacted yet, it may well be down while the operator starts
+	conn, err := sqlx.Open("mysql", config.FormatDSN())
 
 	if err != nil {
-		setupLog.Error(err, "failed to connect to database")
+		setupLog.Error(err, "failed to open the database")
+		os.Exit(1)
+	}
+
+	// the server is known by its address in metrics
+	instance := config.Addr
+
+	db := &server.DB{
+		DB:           conn,
+		Instance:     instance,
+		Observers:    []server.Observer{metrics.ObserveStatement},
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		Limiter:      server.NewLimiter(maxConcurrentWrites, writesPerSecond),
+	}
+
+	if auditLog != "" || auditEvents {
+		auditor := &audit.Log{Writer: ioutil.Discard}
+
+		switch auditLog {
+		case "":
+			// only events
+		case "-":
+			auditor.Writer = os.Stdout
+		default:
+			file, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				setupLog.Error(err, "failed to open the audit log")
+				os.Exit(1)
+			}
+			defer file.Close()
+
+			auditor.Writer = file
+		}
+
+		if auditEvents {
+			auditor.Recorder = mgr.GetEventRecorderFor("sql-audit")
+		}
+
+		db.Observers = append(db.Observers, auditor.Observe)
+	}
+
+	ctrlmetrics.Registry.MustRegister(
+		&metrics.Pool{DB: conn.DB, Instance: instance},
+		&metrics.Inventory{Reader: mgr.GetClient(), Instance: instance},
+	)
+
+	// the manager stays alive without the server, it just isn't ready
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to add the liveness check")
+		os.Exit(1)
+	}
+
+	if clusterID == "" {
+		namespace := &corev1.Namespace{}
+		if err := mgr.GetAPIReader().Get(context.Background(), types.NamespacedName{Name: "kube-system"}, namespace); err != nil {
+			setupLog.Error(err, "failed to read the cluster identity, set --cluster-id")
+			os.Exit(1)
+		}
+
+		clusterID = string(namespace.UID)
+	}
+
+	// capabilities are discovered, and the bookkeeping table created, once the server can be
+	// reached; reconciles wait for that
+	schemas := &ownership.Schemas{DB: db, Schema: bookkeepingSchema}
+	capabilities := &server.Capabilities{}
+	setup := &controllers.ServerSetup{
+		DB:           db,
+		Schemas:      schemas,
+		Capabilities: capabilities,
+		Validate: func(capabilities *server.Capabilities) error {
+			if err := passwordGenerator.AtLeast(password.Requirements(capabilities.Variables)).Validate(); err != nil {
+				return fmt.Errorf("invalid password generator policy: %w", err)
+			}
+
+			return nil
+		},
+		Timeout: readTimeout + writeTimeout,
+		Log:     ctrl.Log.WithName("setup").WithValues("instance", instance),
+	}
+	if err := mgr.Add(setup); err != nil {
+		setupLog.Error(err, "unable to add the server setup")
 		os.Exit(1)
 	}
+
+	if err := mgr.AddReadyzCheck("db-"+instance, readyCheck(db, setup)); err != nil {
+		setupLog.Error(err, "unable to add the readiness check", "instance", instance)
+		os.Exit(1)
+	}
+
+	credentialSinks := map[dbv1alpha1.CredentialSink]credentials.Sink{
+		dbv1alpha1.CredentialSinkSecret: &credentials.Secrets{Client: mgr.GetClient(), Scheme: mgr.GetScheme()},
+	}
+	if vault.Address != "" {
+		vault.Token = os.Getenv("VAULT_TOKEN")
+		credentialSinks[dbv1alpha1.CredentialSinkVault] = &vault
+	}
+
+	if _, ok := credentialSinks[dbv1alpha1.CredentialSink(credentialSink)]; !ok {
+		setupLog.Error(nil, "credential sink is not configured", "sink", credentialSink)
+		os.Exit(1)
+	}
+
 	if err = (&controllers.DatabaseReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Database"),
-		Scheme: mgr.GetScheme(),
-		DB:     db,
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Database"),
+		Scheme:                  mgr.GetScheme(),
+		DB:                      db,
+		Schemas:                 schemas,
+		ClusterID:               clusterID,
+		Recorder:                mgr.GetEventRecorderFor("database-controller"),
+		Resync:                  resync,
+		Context:                 ctx,
+		ReconcileTimeout:        reconcileTimeout,
+		MaxConcurrentReconciles: databaseConcurrency,
+		Setup:                   setup,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Database")
 		os.Exit(1)
 	}
 	if err = (&controllers.UserReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("User"),
-		Scheme: mgr.GetScheme(),
-		DB:     db,
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("User"),
+		Scheme:                  mgr.GetScheme(),
+		DB:                      db,
+		Capabilities:            capabilities,
+		Recorder:                mgr.GetEventRecorderFor("user-controller"),
+		PasswordGenerator:       passwordGenerator,
+		PasswordHashKey:         []byte(passwordHashKey),
+		CredentialSinks:         credentialSinks,
+		DefaultCredentialSink:   dbv1alpha1.CredentialSink(credentialSink),
+		ClusterID:               clusterID,
+		Resync:                  resync,
+		Context:                 ctx,
+		ReconcileTimeout:        reconcileTimeout,
+		MaxConcurrentReconciles: userConcurrency,
+		Setup:                   setup,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "User")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err = (&dbv1alpha1.User{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "User")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(stop); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// readyCheck reports a server as unready until it has been set up and while it can't be
+// reached. The error names the instance, so `/readyz?verbose` shows which one failed, and
+// the server becoming unreachable or reachable again is logged once rather than on every probe.
+func readyCheck(db *server.DB, setup *controllers.ServerSetup) healthz.Checker {
+	log := ctrl.Log.WithName("readyz").WithValues("instance", db.Instance)
+
+	var mu sync.Mutex
+	unreachable := false
+
+	return func(req *http.Request) error {
+		err := db.ReadyCheck(req)
+
+		mu.Lock()
+		if err != nil && !unreachable {
+			log.Error(err, "server is unreachable")
+		} else if err == nil && unreachable {
+			log.Info("server is reachable again")
+		}
+		unreachable = err != nil
+		mu.Unlock()
+
+		if err != nil {
+			return fmt.Errorf("server %s is unreachable: %w", db.Instance, err)
+		}
+
+		if err := setup.ReadyCheck(req); err != nil {
+			return fmt.Errorf("server %s: %w", db.Instance, err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/virtualops/sql-operator/api/v1alpha1"
+)
+
+var (
+	managedUsersDesc = prometheus.NewDesc(namespace+"_managed_users",
+		"Users whose accounts have been created on the server.", []string{"instance"}, nil)
+	managedDatabasesDesc = prometheus.NewDesc(namespace+"_managed_databases",
+		"Databases whose schema has been created on the server.", []string{"instance"}, nil)
+	passwordAgeDesc = prometheus.NewDesc(namespace+"_user_password_age_seconds",
+		"Time since the password of a User's accounts was set.", []string{"instance", "namespace", "name"}, nil)
+)
+
+// Inventory reports the Users and Databases the operator manages on a server. It reads
+// them from the manager's cache whenever it's scraped.
+type Inventory struct {
+	Reader   client.Reader
+	Instance string
+}
+
+// Describe implements prometheus.Collector
+func (i *Inventory) Describe(ch chan<- *prometheus.Desc) {
+	ch <- managedUsersDesc
+	ch <- managedDatabasesDesc
+	ch <- passwordAgeDesc
+}
+
+// Collect implements prometheus.Collector. Nothing is reported while the cache can't be read.
+func (i *Inventory) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	users := &dbv1alpha1.UserList{}
+	if err := i.Reader.List(ctx, users); err == nil {
+		managed := 0
+		for _, user := range users.Items {
+			if user.Status.CreatedAt.IsZero() {
+				continue
+			}
+			managed++
+
+			// users created before the change time was tracked have had their password since creation
+			changedAt := user.Status.CreatedAt
+			if user.Status.PasswordChangedAt != nil {
+				changedAt = *user.Status.PasswordChangedAt
+			}
+
+			ch <- prometheus.MustNewConstMetric(passwordAgeDesc, prometheus.GaugeValue,
+				time.Since(changedAt.Time).Seconds(), i.Instance, user.Namespace, user.Name)
+		}
+
+		ch <- prometheus.MustNewConstMetric(managedUsersDesc, prometheus.GaugeValue, float64(managed), i.Instance)
+	}
+
+	databases := &dbv1alpha1.DatabaseList{}
+	if err := i.Reader.List(ctx, databases); err == nil {
+		managed := 0
+		for _, db := range databases.Items {
+			if !db.Status.CreatedAt.IsZero() {
+				managed++
+			}
+		}
+
+		ch <- prometheus.MustNewConstMetric(managedDatabasesDesc, prometheus.GaugeValue, float64(managed), i.Instance)
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/virtualops/sql-operator/server"
+)
+
+const namespace = "sql_operator"
+
+var (
+	// Statements counts the statements run on each server by kind and outcome
+	Statements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "statements_total",
+		Help:      "Statements run on the server, by kind and outcome.",
+	}, []string{"instance", "kind", "outcome"})
+
+	// StatementDuration is how long statements take on each server
+	StatementDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "statement_duration_seconds",
+		Help:      "How long statements take on the server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"instance", "kind"})
+
+	// DriftDetections counts objects found changed or dropped outside the operator
+	DriftDetections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drift_detections_total",
+		Help:      "Objects on the server found changed or dropped outside the operator, by kind.",
+	}, []string{"instance", "kind"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(Statements, StatementDuration, DriftDetections)
+}
+
+// ObserveStatement records a statement that finished, and failed with err if it isn't nil.
+// It is a server.Observer.
+func ObserveStatement(_ context.Context, instance, statement string, _ []interface{}, duration time.Duration, err error) {
+	kind := StatementKind(statement)
+
+	outcome := "success"
+	if err != nil {
+		outcome = strings.ToLower(string(server.ClassifyError(err)))
+	}
+
+	Statements.WithLabelValues(instance, kind, outcome).Inc()
+	StatementDuration.WithLabelValues(instance, kind).Observe(duration.Seconds())
+}
+
+// StatementKind is the leading keyword of a statement, with the kind of object for the
+// statements that name one, such as `SELECT` or `CREATE USER`
+func StatementKind(statement string) string {
+	words := strings.Fields(strings.ToUpper(statement))
+	if len(words) == 0 {
+		return ""
+	}
+
+	switch words[0] {
+	case "CREATE", "ALTER", "DROP", "RENAME":
+		if len(words) > 1 {
+			return words[0] + " " + words[1]
+		}
+	}
+
+	return words[0]
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementKind(t *testing.T) {
+	assert.Equal(t, "CREATE USER", StatementKind("CREATE USER 'app'@'%' IDENTIFIED BY 'secret'"))
+	assert.Equal(t, "CREATE DATABASE", StatementKind("CREATE DATABASE IF NOT EXISTS `app`"))
+	assert.Equal(t, "GRANT", StatementKind("GRANT SELECT ON `app`.* TO 'app'@'%'"))
+	assert.Equal(t, "SELECT", StatementKind("\n\tselect Host from mysql.user"))
+	assert.Equal(t, "", StatementKind("  "))
+}
+
+func TestObserveStatement(t *testing.T) {
+	ObserveStatement(context.Background(), "test", "DROP USER 'app'@'%'", nil, time.Millisecond, nil)
+	ObserveStatement(context.Background(), "test", "DROP USER 'app'@'%'", nil, time.Millisecond, &mysql.MySQLError{Number: 1396})
+	ObserveStatement(context.Background(), "test", "DROP USER 'app'@'%'", nil, time.Millisecond, &mysql.MySQLError{Number: 1396})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(Statements.WithLabelValues("test", "DROP USER", "success")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(Statements.WithLabelValues("test", "DROP USER", "conflict")))
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	maxOpenDesc = prometheus.NewDesc(namespace+"_db_max_open_connections",
+		"Maximum number of open connections to the server, 0 is unlimited.", []string{"instance"}, nil)
+	openDesc = prometheus.NewDesc(namespace+"_db_open_connections",
+		"Open connections to the server, in use or idle.", []string{"instance"}, nil)
+	inUseDesc = prometheus.NewDesc(namespace+"_db_in_use_connections",
+		"Connections to the server that are in use.", []string{"instance"}, nil)
+	idleDesc = prometheus.NewDesc(namespace+"_db_idle_connections",
+		"Idle connections to the server.", []string{"instance"}, nil)
+	waitCountDesc = prometheus.NewDesc(namespace+"_db_wait_count_total",
+		"Times a statement waited for a connection to the server.", []string{"instance"}, nil)
+	waitDurationDesc = prometheus.NewDesc(namespace+"_db_wait_duration_seconds_total",
+		"Time spent waiting for connections to the server.", []string{"instance"}, nil)
+	closedDesc = prometheus.NewDesc(namespace+"_db_closed_connections_total",
+		"Connections to the server closed for being idle or too old, by reason.", []string{"instance", "reason"}, nil)
+)
+
+// Pool reports the connection pool statistics of a server's sql.DB
+type Pool struct {
+	DB       *sql.DB
+	Instance string
+}
+
+// Describe implements prometheus.Collector
+func (p *Pool) Describe(ch chan<- *prometheus.Desc) {
+	ch <- maxOpenDesc
+	ch <- openDesc
+	ch <- inUseDesc
+	ch <- idleDesc
+	ch <- waitCountDesc
+	ch <- waitDurationDesc
+	ch <- closedDesc
+}
+
+// Collect implements prometheus.Collector
+func (p *Pool) Collect(ch chan<- prometheus.Metric) {
+	stats := p.DB.Stats()
+
+	ch <- prometheus.MustNewConstMetric(maxOpenDesc, prometheus.GaugeValue, float64(stats.MaxOpenConnections), p.Instance)
+	ch <- prometheus.MustNewConstMetric(openDesc, prometheus.GaugeValue, float64(stats.OpenConnections), p.Instance)
+	ch <- prometheus.MustNewConstMetric(inUseDesc, prometheus.GaugeValue, float64(stats.InUse), p.Instance)
+	ch <- prometheus.MustNewConstMetric(idleDesc, prometheus.GaugeValue, float64(stats.Idle), p.Instance)
+	ch <- prometheus.MustNewConstMetric(waitCountDesc, prometheus.CounterValue, float64(stats.WaitCount), p.Instance)
+	ch <- prometheus.MustNewConstMetric(waitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds(), p.Instance)
+	ch <- prometheus.MustNewConstMetric(closedDesc, prometheus.CounterValue, float64(stats.MaxIdleClosed), p.Instance, "max_idle")
+	ch <- prometheus.MustNewConstMetric(closedDesc, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), p.Instance, "max_lifetime")
+}
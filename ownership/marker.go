@@ -0,0 +1,67 @@
+package ownership
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// AttributeKey is the key markers are stored under in an account's JSON attribute,
+// so they can live next to attributes DBAs set themselves
+const AttributeKey = "db.breeze.sh"
+
+// Marker records which custom resource manages an object on the server
+type Marker struct {
+	// Cluster identifies the Kubernetes cluster, since several may share a server
+	Cluster   string `json:"cluster"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+}
+
+func (m Marker) String() string {
+	return fmt.Sprintf("%s %s/%s in cluster %s", m.Kind, m.Namespace, m.Name, m.Cluster)
+}
+
+// SameOwner reports whether two markers name the same resource. The UID isn't compared:
+// a resource that is recreated or restored from a backup gets a new UID but is still
+// the same owner, and has its marker updated.
+func (m Marker) SameOwner(other Marker) bool {
+	return m.Cluster == other.Cluster &&
+		m.Kind == other.Kind &&
+		m.Namespace == other.Namespace &&
+		m.Name == other.Name
+}
+
+// Attribute renders the marker as the JSON object passed to `CREATE USER ... ATTRIBUTE`
+func (m Marker) Attribute() (string, error) {
+	encoded, err := json.Marshal(map[string]Marker{AttributeKey: m})
+
+	return string(encoded), err
+}
+
+// ParseAttribute reads the marker from an account's JSON attribute, returning nil
+// if the account has no attribute or no marker
+func ParseAttribute(attribute string) (*Marker, error) {
+	if strings.TrimSpace(attribute) == "" {
+		return nil, nil
+	}
+
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(attribute), &attributes); err != nil {
+		return nil, err
+	}
+
+	raw, ok := attributes[AttributeKey]
+	if !ok {
+		return nil, nil
+	}
+
+	marker := &Marker{}
+	if err := json.Unmarshal(raw, marker); err != nil {
+		return nil, err
+	}
+
+	return marker, nil
+}
@@ -0,0 +1,46 @@
+package ownership
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var marker = Marker{Cluster: "production", Kind: "User", Namespace: "payments", Name: "example", UID: "2f1c"}
+
+func TestAttributeRoundTrip(t *testing.T) {
+	attribute, err := marker.Attribute()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"db.breeze.sh": {"cluster": "production", "kind": "User", "namespace": "payments", "name": "example", "uid": "2f1c"}}`, attribute)
+
+	parsed, err := ParseAttribute(attribute)
+	assert.NoError(t, err)
+	assert.Equal(t, &marker, parsed)
+}
+
+func TestParseAttributeWithoutMarker(t *testing.T) {
+	parsed, err := ParseAttribute("")
+	assert.NoError(t, err)
+	assert.Nil(t, parsed)
+
+	parsed, err = ParseAttribute(`{"team": "payments"}`)
+	assert.NoError(t, err)
+	assert.Nil(t, parsed)
+
+	_, err = ParseAttribute(`not json`)
+	assert.Error(t, err)
+}
+
+func TestSameOwner(t *testing.T) {
+	recreated := marker
+	recreated.UID = "9b7e"
+	assert.True(t, marker.SameOwner(recreated))
+
+	otherCluster := marker
+	otherCluster.Cluster = "staging"
+	assert.False(t, marker.SameOwner(otherCluster))
+
+	otherName := marker
+	otherName.Name = "example-2"
+	assert.False(t, marker.SameOwner(otherName))
+}
@@ -0,0 +1,105 @@
+package ownership
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/virtualops/sql-operator/server"
+)
+
+// Schemas have no place for attributes, so their markers are kept in a bookkeeping
+// table, in a schema of its own
+type Schemas struct {
+	DB *server.DB
+	// Schema holds the bookkeeping table
+	Schema string
+}
+
+func (s *Schemas) table() string {
+	return fmt.Sprintf("`%s`.`schemas`", s.Schema)
+}
+
+// EnsureTable creates the bookkeeping schema and table if they don't exist
+func (s *Schemas) EnsureTable(ctx context.Context) error {
+	if _, err := s.DB.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", s.Schema)); err != nil {
+		return err
+	}
+
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		schema_name VARCHAR(64) NOT NULL PRIMARY KEY,
+		cluster VARCHAR(255) NOT NULL,
+		kind VARCHAR(64) NOT NULL,
+		namespace VARCHAR(253) NOT NULL,
+		name VARCHAR(253) NOT NULL,
+		uid VARCHAR(36) NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+	)`, s.table()))
+
+	return err
+}
+
+// Owner returns the marker recorded for a schema, or nil if there is none
+func (s *Schemas) Owner(ctx context.Context, schema string) (*Marker, error) {
+	marker := &Marker{}
+	err := s.DB.GetContext(ctx, marker, fmt.Sprintf("SELECT cluster, kind, namespace, name, uid FROM %s WHERE schema_name = ?", s.table()), schema)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return marker, nil
+}
+
+// State tells, in a single query, whether a schema exists and which marker is recorded
+// for it, if any
+func (s *Schemas) State(ctx context.Context, schema string) (bool, *Marker, error) {
+	row := struct {
+		Exists    bool           `db:"present"`
+		Cluster   sql.NullString `db:"cluster"`
+		Kind      sql.NullString `db:"kind"`
+		Namespace sql.NullString `db:"namespace"`
+		Name      sql.NullString `db:"name"`
+		UID       sql.NullString `db:"uid"`
+	}{}
+
+	err := s.DB.GetContext(ctx, &row, fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM INFORMATION_SCHEMA.SCHEMATA WHERE SCHEMA_NAME = ?) AS present,
+		b.cluster, b.kind, b.namespace, b.name, b.uid
+		FROM (SELECT 1) one LEFT JOIN %s b ON b.schema_name = ?`, s.table()), schema, schema)
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	if !row.Cluster.Valid {
+		return row.Exists, nil, nil
+	}
+
+	return row.Exists, &Marker{
+		Cluster:   row.Cluster.String,
+		Kind:      row.Kind.String,
+		Namespace: row.Namespace.String,
+		Name:      row.Name.String,
+		UID:       row.UID.String,
+	}, nil
+}
+
+// Record stores the marker for a schema, replacing any previous one
+func (s *Schemas) Record(ctx context.Context, schema string, marker Marker) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (schema_name, cluster, kind, namespace, name, uid) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE cluster = VALUES(cluster), kind = VALUES(kind), namespace = VALUES(namespace), name = VALUES(name), uid = VALUES(uid)`, s.table()),
+		schema, marker.Cluster, marker.Kind, marker.Namespace, marker.Name, marker.UID)
+
+	return err
+}
+
+// Forget removes the marker for a schema
+func (s *Schemas) Forget(ctx context.Context, schema string) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE schema_name = ?", s.table()), schema)
+
+	return err
+}
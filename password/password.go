@@ -0,0 +1,203 @@
+package password
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	lowercase = "abcdefghijklmnopqrstuvwxyz"
+	uppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digits    = "0123456789"
+	// special characters are limited to ones that don't need quoting in DSNs, URLs or shells
+	special = "-_.~+=^"
+)
+
+// Policy describes the passwords the generator produces. Every character class is
+// used to fill the password, to leave one out exclude all of its characters.
+type Policy struct {
+	Length       int
+	MinLowercase int
+	MinUppercase int
+	MinDigits    int
+	MinSpecial   int
+	// Exclude lists characters that never appear in generated passwords
+	Exclude string
+}
+
+// DefaultPolicy satisfies `validate_password` with the MEDIUM policy and its default settings
+var DefaultPolicy = Policy{
+	Length:       24,
+	MinLowercase: 1,
+	MinUppercase: 1,
+	MinDigits:    1,
+	MinSpecial:   1,
+}
+
+// Requirements reads the minimums enforced by `validate_password` from the server's
+// system variables. Both the MySQL 8 component (`validate_password.length`) and the
+// MySQL 5.7 plugin (`validate_password_length`) are recognised. A server without
+// `validate_password` has no requirements.
+func Requirements(variables map[string]string) Policy {
+	lookup := func(name string) int {
+		for _, separator := range []string{".", "_"} {
+			if value, ok := variables["validate_password"+separator+name]; ok {
+				n, _ := strconv.Atoi(value)
+				return n
+			}
+		}
+
+		return 0
+	}
+
+	requirements := Policy{Length: lookup("length")}
+
+	// the LOW policy only checks the length
+	policy, ok := variables["validate_password.policy"]
+	if !ok {
+		policy = variables["validate_password_policy"]
+	}
+	if strings.EqualFold(policy, "LOW") || policy == "0" {
+		return requirements
+	}
+
+	requirements.MinLowercase = lookup("mixed_case_count")
+	requirements.MinUppercase = lookup("mixed_case_count")
+	requirements.MinDigits = lookup("number_count")
+	requirements.MinSpecial = lookup("special_char_count")
+
+	return requirements
+}
+
+// AtLeast raises the policy's length and minimums to meet the requirements
+func (p Policy) AtLeast(requirements Policy) Policy {
+	p.Length = maxInt(p.Length, requirements.Length)
+	p.MinLowercase = maxInt(p.MinLowercase, requirements.MinLowercase)
+	p.MinUppercase = maxInt(p.MinUppercase, requirements.MinUppercase)
+	p.MinDigits = maxInt(p.MinDigits, requirements.MinDigits)
+	p.MinSpecial = maxInt(p.MinSpecial, requirements.MinSpecial)
+
+	return p
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+type class struct {
+	name       string
+	characters string
+	minimum    int
+}
+
+func (p Policy) classes() []class {
+	return []class{
+		{"lowercase", p.allowed(lowercase), p.MinLowercase},
+		{"uppercase", p.allowed(uppercase), p.MinUppercase},
+		{"digit", p.allowed(digits), p.MinDigits},
+		{"special", p.allowed(special), p.MinSpecial},
+	}
+}
+
+func (p Policy) allowed(characters string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(p.Exclude, r) {
+			return -1
+		}
+
+		return r
+	}, characters)
+}
+
+// Validate checks that passwords can be generated under the policy
+func (p Policy) Validate() error {
+	if p.Length <= 0 {
+		return errors.New("password length must be positive")
+	}
+
+	required, available := 0, 0
+	for _, class := range p.classes() {
+		if class.minimum > 0 && class.characters == "" {
+			return fmt.Errorf("at least %d %s characters are required, but all of them are excluded", class.minimum, class.name)
+		}
+
+		required += class.minimum
+		available += len(class.characters)
+	}
+
+	if required > p.Length {
+		return fmt.Errorf("the character minimums add up to %d, more than the length of %d", required, p.Length)
+	}
+
+	if available == 0 {
+		return errors.New("all characters are excluded")
+	}
+
+	return nil
+}
+
+// Generate creates a random password following the policy, using crypto/rand
+func Generate(p Policy) (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+
+	var password []byte
+	var pool string
+	for _, class := range p.classes() {
+		for i := 0; i < class.minimum; i++ {
+			c, err := pick(class.characters)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c)
+		}
+
+		pool += class.characters
+	}
+
+	for len(password) < p.Length {
+		c, err := pick(pool)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// shuffle so the required characters aren't always up front
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+
+	return string(password), nil
+}
+
+func pick(characters string) (byte, error) {
+	i, err := randomInt(len(characters))
+	if err != nil {
+		return 0, err
+	}
+
+	return characters[i], nil
+}
+
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(i.Int64()), nil
+}
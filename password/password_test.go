@@ -0,0 +1,75 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func count(password, characters string) int {
+	n := 0
+	for _, c := range password {
+		if strings.ContainsRune(characters, c) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestGenerate(t *testing.T) {
+	policy := Policy{Length: 32, MinLowercase: 2, MinUppercase: 3, MinDigits: 4, MinSpecial: 5, Exclude: "0Ol1"}
+
+	for i := 0; i < 50; i++ {
+		password, err := Generate(policy)
+		assert.NoError(t, err)
+		assert.Len(t, password, 32)
+		assert.GreaterOrEqual(t, count(password, lowercase), 2)
+		assert.GreaterOrEqual(t, count(password, uppercase), 3)
+		assert.GreaterOrEqual(t, count(password, digits), 4)
+		assert.GreaterOrEqual(t, count(password, special), 5)
+		assert.Zero(t, count(password, "0Ol1"))
+	}
+}
+
+func TestGenerateLeavesOutExcludedClasses(t *testing.T) {
+	password, err := Generate(Policy{Length: 64, Exclude: special + uppercase})
+	assert.NoError(t, err)
+	assert.Equal(t, len(password), count(password, lowercase+digits))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, DefaultPolicy.Validate())
+	assert.Error(t, Policy{}.Validate())
+	assert.Error(t, Policy{Length: 3, MinDigits: 2, MinSpecial: 2}.Validate())
+	assert.Error(t, Policy{Length: 8, MinDigits: 1, Exclude: digits}.Validate())
+	assert.Error(t, Policy{Length: 8, Exclude: lowercase + uppercase + digits + special}.Validate())
+}
+
+func TestRequirements(t *testing.T) {
+	component := map[string]string{
+		"validate_password.length":             "12",
+		"validate_password.mixed_case_count":   "2",
+		"validate_password.number_count":       "1",
+		"validate_password.special_char_count": "1",
+		"validate_password.policy":             "MEDIUM",
+	}
+	assert.Equal(t, Policy{Length: 12, MinLowercase: 2, MinUppercase: 2, MinDigits: 1, MinSpecial: 1}, Requirements(component))
+
+	plugin := map[string]string{
+		"validate_password_length":             "8",
+		"validate_password_mixed_case_count":   "1",
+		"validate_password_number_count":       "1",
+		"validate_password_special_char_count": "1",
+		"validate_password_policy":             "LOW",
+	}
+	assert.Equal(t, Policy{Length: 8}, Requirements(plugin))
+
+	assert.Equal(t, Policy{}, Requirements(map[string]string{}))
+}
+
+func TestAtLeast(t *testing.T) {
+	policy := Policy{Length: 16, MinDigits: 3, Exclude: "x"}.AtLeast(Policy{Length: 20, MinDigits: 1, MinSpecial: 2})
+	assert.Equal(t, Policy{Length: 20, MinDigits: 3, MinSpecial: 2, Exclude: "x"}, policy)
+}
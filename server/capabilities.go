@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Flavor string
+
+const (
+	FlavorMySQL   Flavor = "MySQL"
+	FlavorMariaDB Flavor = "MariaDB"
+)
+
+type Feature string
+
+const (
+	// FeatureIfExists covers `CREATE USER IF NOT EXISTS` and `DROP USER IF EXISTS`
+	FeatureIfExists              Feature = "IfExists"
+	FeatureRoles                 Feature = "Roles"
+	FeatureDynamicPrivileges     Feature = "DynamicPrivileges"
+	FeatureRetainCurrentPassword Feature = "RetainCurrentPassword"
+	FeatureAccountAttributes     Feature = "AccountAttributes"
+	FeaturePartialRevokes        Feature = "PartialRevokes"
+	FeatureAccountLock           Feature = "AccountLock"
+	FeaturePasswordExpiry        Feature = "PasswordExpiry"
+	FeaturePasswordHistory       Feature = "PasswordHistory"
+	FeatureFailedLoginTracking   Feature = "FailedLoginTracking"
+)
+
+// minimumVersions lists the first server version supporting each feature, per flavor.
+// A feature that is missing for a flavor is not supported by that flavor at all.
+var minimumVersions = map[Flavor]map[Feature]Version{
+	FlavorMySQL: {
+		FeatureIfExists:              {5, 7, 8},
+		FeatureRoles:                 {8, 0, 0},
+		FeatureDynamicPrivileges:     {8, 0, 0},
+		FeatureRetainCurrentPassword: {8, 0, 14},
+		FeatureAccountAttributes:     {8, 0, 21},
+		FeaturePartialRevokes:        {8, 0, 16},
+		FeatureAccountLock:           {5, 7, 6},
+		FeaturePasswordExpiry:        {5, 7, 6},
+		FeaturePasswordHistory:       {8, 0, 3},
+		FeatureFailedLoginTracking:   {8, 0, 19},
+	},
+	FlavorMariaDB: {
+		FeatureIfExists:       {10, 1, 3},
+		FeatureRoles:          {10, 0, 5},
+		FeatureAccountLock:    {10, 4, 2},
+		FeaturePasswordExpiry: {10, 4, 3},
+	},
+}
+
+// requiredVariables lists features that additionally need a system variable switched on
+var requiredVariables = map[Feature]string{
+	FeaturePartialRevokes: "partial_revokes",
+}
+
+// PasswordVariables are the `validate_password` settings, as a `LIKE` pattern. They can be
+// changed while the server runs.
+const PasswordVariables = "validate_password%"
+
+// variablePatterns are the system variables we read at discovery time, as `LIKE` patterns
+var variablePatterns = []string{
+	"partial_revokes",
+	"default_authentication_plugin",
+	"default_password_lifetime",
+	PasswordVariables,
+}
+
+type Version [3]int
+
+func (v Version) AtLeast(other Version) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] > other[i]
+		}
+	}
+
+	return true
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// Capabilities describes what a single server connection supports. It is discovered
+// once the server is first reached, and shared by the reconcilers using that connection.
+type Capabilities struct {
+	Flavor  Flavor
+	Version Version
+	// Privileges is the set of privilege names reported by `SHOW PRIVILEGES`, upper-cased
+	Privileges map[string]bool
+	Variables  map[string]string
+	// AuthenticationPlugins is the set of active authentication plugins
+	AuthenticationPlugins map[string]bool
+}
+
+// UnsupportedError is returned when a spec asks for something the server can't do.
+// Reconcilers report it as a condition rather than retrying.
+type UnsupportedError struct {
+	Reason  string
+	Message string
+}
+
+func (e *UnsupportedError) Error() string {
+	return e.Message
+}
+
+// Discover reads what the server supports. It gives up when ctx is done, so a server that
+// stopped answering doesn't hold up startup.
+func Discover(ctx context.Context, db *sqlx.DB) (*Capabilities, error) {
+	var version string
+	if err := db.GetContext(ctx, &version, "SELECT VERSION()"); err != nil {
+		return nil, err
+	}
+
+	caps, err := ParseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SHOW PRIVILEGES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var privilege, context, comment string
+		if err := rows.Scan(&privilege, &context, &comment); err != nil {
+			return nil, err
+		}
+
+		caps.Privileges[strings.ToUpper(privilege)] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	plugins, err := db.QueryContext(ctx, "SELECT PLUGIN_NAME FROM INFORMATION_SCHEMA.PLUGINS WHERE PLUGIN_TYPE = 'AUTHENTICATION' AND PLUGIN_STATUS = 'ACTIVE'")
+	if err != nil {
+		return nil, err
+	}
+	defer plugins.Close()
+
+	for plugins.Next() {
+		var plugin string
+		if err := plugins.Scan(&plugin); err != nil {
+			return nil, err
+		}
+
+		caps.AuthenticationPlugins[plugin] = true
+	}
+
+	if err := plugins.Err(); err != nil {
+		return nil, err
+	}
+
+	if caps.Variables, err = ReadVariables(ctx, db, variablePatterns...); err != nil {
+		return nil, err
+	}
+
+	return caps, nil
+}
+
+// ReadVariables reads the global system variables matching the `LIKE` patterns
+func ReadVariables(ctx context.Context, db *sqlx.DB, patterns ...string) (map[string]string, error) {
+	variables := map[string]string{}
+
+	for _, pattern := range patterns {
+		rows, err := db.QueryContext(ctx, "SHOW GLOBAL VARIABLES LIKE ?", pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var name, value string
+			if err := rows.Scan(&name, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			variables[name] = value
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return variables, nil
+}
+
+// ParseVersion builds an empty capability set from a `VERSION()` string
+// such as `8.0.32`, `5.7.42-log` or `10.6.12-MariaDB-1:10.6.12+maria~ubu2004`.
+func ParseVersion(version string) (*Capabilities, error) {
+	caps := &Capabilities{
+		Flavor:                FlavorMySQL,
+		Privileges:            map[string]bool{},
+		Variables:             map[string]string{},
+		AuthenticationPlugins: map[string]bool{},
+	}
+
+	if strings.Contains(version, "MariaDB") {
+		caps.Flavor = FlavorMariaDB
+	}
+
+	number := version
+	if i := strings.IndexAny(number, "-+~"); i >= 0 {
+		number = number[:i]
+	}
+
+	parts := strings.SplitN(number, ".", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unrecognised server version %q", version)
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("unrecognised server version %q", version)
+		}
+
+		caps.Version[i] = n
+	}
+
+	return caps, nil
+}
+
+func (c *Capabilities) Supports(feature Feature) bool {
+	return c.supportsVersion(feature) && c.variableEnabled(feature)
+}
+
+func (c *Capabilities) supportsVersion(feature Feature) bool {
+	minimum, ok := minimumVersions[c.Flavor][feature]
+
+	return ok && c.Version.AtLeast(minimum)
+}
+
+func (c *Capabilities) variableEnabled(feature Feature) bool {
+	variable, ok := requiredVariables[feature]
+	if !ok {
+		return true
+	}
+
+	value := c.Variables[variable]
+
+	return strings.EqualFold(value, "ON") || value == "1"
+}
+
+// Variable returns the value of a system variable read at discovery time
+func (c *Capabilities) Variable(name string) (string, bool) {
+	value, ok := c.Variables[name]
+
+	return value, ok
+}
+
+// ValidatePrivileges checks that every privilege is known to the server. This catches
+// dynamic privileges like BACKUP_ADMIN on servers that don't have them.
+func (c *Capabilities) ValidatePrivileges(privileges []string) error {
+	var unknown []string
+	for _, privilege := range privileges {
+		name := strings.ToUpper(privilege)
+		if name == "*" || name == "ALL" || name == "ALL PRIVILEGES" {
+			continue
+		}
+
+		if !c.Privileges[name] {
+			unknown = append(unknown, privilege)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return &UnsupportedError{
+			Reason:  "UnsupportedPrivilege",
+			Message: fmt.Sprintf("%s %s does not support privileges: %s", c.Flavor, c.Version, strings.Join(unknown, ", ")),
+		}
+	}
+
+	return nil
+}
+
+// ValidateAuthenticationPlugin checks that the plugin is installed and active on the server
+func (c *Capabilities) ValidateAuthenticationPlugin(plugin string) error {
+	if plugin == "" || c.AuthenticationPlugins[plugin] {
+		return nil
+	}
+
+	return &UnsupportedError{
+		Reason:  "UnsupportedAuthenticationPlugin",
+		Message: fmt.Sprintf("authentication plugin %s is not active on the server", plugin),
+	}
+}
+
+// Require returns an UnsupportedError if the server lacks the feature
+func (c *Capabilities) Require(feature Feature) error {
+	if c.Supports(feature) {
+		return nil
+	}
+
+	if c.supportsVersion(feature) {
+		return &UnsupportedError{
+			Reason:  "Unsupported" + string(feature),
+			Message: fmt.Sprintf("%s requires %s to be enabled on the server", feature, requiredVariables[feature]),
+		}
+	}
+
+	return &UnsupportedError{
+		Reason:  "Unsupported" + string(feature),
+		Message: fmt.Sprintf("%s %s does not support %s", c.Flavor, c.Version, feature),
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	caps, err := ParseVersion("8.0.32")
+	assert.NoError(t, err)
+	assert.Equal(t, FlavorMySQL, caps.Flavor)
+	assert.Equal(t, Version{8, 0, 32}, caps.Version)
+
+	caps, err = ParseVersion("5.7.42-log")
+	assert.NoError(t, err)
+	assert.Equal(t, Version{5, 7, 42}, caps.Version)
+
+	caps, err = ParseVersion("10.6.12-MariaDB-1:10.6.12+maria~ubu2004")
+	assert.NoError(t, err)
+	assert.Equal(t, FlavorMariaDB, caps.Flavor)
+	assert.Equal(t, Version{10, 6, 12}, caps.Version)
+
+	_, err = ParseVersion("not-a-version")
+	assert.Error(t, err)
+}
+
+func TestSupports(t *testing.T) {
+	mysql57, _ := ParseVersion("5.7.42")
+	mysql80, _ := ParseVersion("8.0.32")
+	mariadb, _ := ParseVersion("10.6.12-MariaDB")
+
+	assert.True(t, mysql57.Supports(FeatureIfExists))
+	assert.False(t, mysql57.Supports(FeatureRoles))
+	assert.True(t, mysql80.Supports(FeatureDynamicPrivileges))
+	assert.True(t, mysql80.Supports(FeatureAccountAttributes))
+	assert.True(t, mariadb.Supports(FeatureRoles))
+	assert.False(t, mariadb.Supports(FeatureDynamicPrivileges))
+
+	assert.NoError(t, mysql80.Require(FeatureRetainCurrentPassword))
+	assert.IsType(t, &UnsupportedError{}, mysql57.Require(FeatureRetainCurrentPassword))
+}
+
+func TestValidatePrivileges(t *testing.T) {
+	caps, _ := ParseVersion("5.7.42")
+	caps.Privileges["SELECT"] = true
+	caps.Privileges["CREATE ROUTINE"] = true
+
+	assert.NoError(t, caps.ValidatePrivileges([]string{"*"}))
+	assert.NoError(t, caps.ValidatePrivileges([]string{"select", "Create routine"}))
+
+	err := caps.ValidatePrivileges([]string{"SELECT", "BACKUP_ADMIN"})
+	assert.Error(t, err)
+	assert.Equal(t, "UnsupportedPrivilege", err.(*UnsupportedError).Reason)
+	assert.Contains(t, err.Error(), "BACKUP_ADMIN")
+}
+
+func TestSupportsWithVariable(t *testing.T) {
+	caps, _ := ParseVersion("8.0.32")
+	assert.False(t, caps.Supports(FeaturePartialRevokes))
+
+	err := caps.Require(FeaturePartialRevokes)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "partial_revokes")
+
+	caps.Variables["partial_revokes"] = "ON"
+	assert.True(t, caps.Supports(FeaturePartialRevokes))
+
+	old, _ := ParseVersion("8.0.15")
+	old.Variables["partial_revokes"] = "ON"
+	assert.False(t, old.Supports(FeaturePartialRevokes))
+}
+
+func TestValidateAuthenticationPlugin(t *testing.T) {
+	caps, _ := ParseVersion("8.0.32")
+	caps.AuthenticationPlugins["caching_sha2_password"] = true
+
+	assert.NoError(t, caps.ValidateAuthenticationPlugin(""))
+	assert.NoError(t, caps.ValidateAuthenticationPlugin("caching_sha2_password"))
+
+	err := caps.ValidateAuthenticationPlugin("auth_socket")
+	assert.Error(t, err)
+	assert.Equal(t, "UnsupportedAuthenticationPlugin", err.(*UnsupportedError).Reason)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Observer is told about every statement run through a DB, once it has finished
+type Observer func(ctx context.Context, instance, statement string, args []interface{}, duration time.Duration, err error)
+
+// DB runs statements against one server, telling its observers about each of them
+type DB struct {
+	*sqlx.DB
+	// Instance names the server in metrics and logs
+	Instance  string
+	Observers []Observer
+	// ReadTimeout bounds GetContext and SelectContext, WriteTimeout bounds ExecContext.
+	// 0 leaves it to the caller's context.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Limiter, if set, caps the statements ExecContext runs at once and per second.
+	// Waiting for it doesn't count towards WriteTimeout.
+	Limiter *Limiter
+	// Locks serialize the work on each schema and account
+	Locks Locks
+}
+
+// ReadyCheck pings the server, bounded by ReadTimeout. It is a healthz.Checker.
+func (db *DB) ReadyCheck(req *http.Request) error {
+	ctx, cancel := withTimeout(req.Context(), db.ReadTimeout)
+	defer cancel()
+
+	return db.PingContext(ctx)
+}
+
+// withTimeout bounds a single statement
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (db *DB) observe(ctx context.Context, statement string, args []interface{}, start time.Time, err error) {
+	duration := time.Since(start)
+	for _, observer := range db.Observers {
+		observer(ctx, db.Instance, statement, args, duration, err)
+	}
+}
+
+// ExecContext is sqlx's ExecContext, observed
+func (db *DB) ExecContext(ctx context.Context, statement string, args ...interface{}) (sql.Result, error) {
+	if db.Limiter != nil {
+		release, err := db.Limiter.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	timeoutCtx, cancel := withTimeout(ctx, db.WriteTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := db.DB.ExecContext(timeoutCtx, statement, args...)
+	db.observe(ctx, statement, args, start, err)
+
+	return result, err
+}
+
+// QueryContext is sqlx's QueryContext, observed. The rows outlive the call, so only the
+// caller's context bounds it.
+func (db *DB) QueryContext(ctx context.Context, statement string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.DB.QueryContext(ctx, statement, args...)
+	db.observe(ctx, statement, args, start, err)
+
+	return rows, err
+}
+
+// QueryRowContext is sqlx's QueryRowContext, observed. Only the caller's context bounds it.
+func (db *DB) QueryRowContext(ctx context.Context, statement string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := db.DB.QueryRowContext(ctx, statement, args...)
+	db.observe(ctx, statement, args, start, row.Err())
+
+	return row
+}
+
+// GetContext is sqlx's GetContext, observed
+func (db *DB) GetContext(ctx context.Context, dest interface{}, statement string, args ...interface{}) error {
+	timeoutCtx, cancel := withTimeout(ctx, db.ReadTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := db.DB.GetContext(timeoutCtx, dest, statement, args...)
+	db.observe(ctx, statement, args, start, err)
+
+	return err
+}
+
+// SelectContext is sqlx's SelectContext, observed
+func (db *DB) SelectContext(ctx context.Context, dest interface{}, statement string, args ...interface{}) error {
+	timeoutCtx, cancel := withTimeout(ctx, db.ReadTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := db.DB.SelectContext(timeoutCtx, dest, statement, args...)
+	db.observe(ctx, statement, args, start, err)
+
+	return err
+}
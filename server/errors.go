@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrorClass decides how a failed statement is retried
+type ErrorClass string
+
+const (
+	// ErrorTransient errors may go away by themselves, so the statement is retried with backoff
+	ErrorTransient ErrorClass = "Transient"
+	// ErrorTerminal errors will be returned again until the spec changes
+	ErrorTerminal ErrorClass = "Terminal"
+	// ErrorConflict errors mean the server doesn't hold what we expected, such as an account
+	// that already exists or a grant that's already gone. They'll be returned again until
+	// someone changes the server.
+	ErrorConflict ErrorClass = "Conflict"
+)
+
+var errorClasses = map[uint16]ErrorClass{
+	1040: ErrorTransient, // too many connections
+	1053: ErrorTransient, // server shutdown in progress
+	1158: ErrorTransient, // error reading communication packets
+	1159: ErrorTransient, // timeout reading communication packets
+	1160: ErrorTransient, // error writing communication packets
+	1161: ErrorTransient, // timeout writing communication packets
+	1203: ErrorTransient, // user already has more than max_user_connections
+	1205: ErrorTransient, // lock wait timeout
+	1213: ErrorTransient, // deadlock
+	1290: ErrorTransient, // running with --read-only, such as during a failover
+	1317: ErrorTransient, // query interrupted
+	1836: ErrorTransient, // running in read-only mode
+	1927: ErrorTransient, // connection was killed (MariaDB)
+	2006: ErrorTransient, // server has gone away
+	2013: ErrorTransient, // lost connection during query
+	3024: ErrorTransient, // max_execution_time exceeded
+
+	1044: ErrorTerminal, // access denied to database
+	1045: ErrorTerminal, // access denied for user
+	1064: ErrorTerminal, // syntax error
+	1102: ErrorTerminal, // incorrect database name
+	1115: ErrorTerminal, // unknown character set
+	1142: ErrorTerminal, // command denied on table
+	1227: ErrorTerminal, // needs a privilege like SUPER or CREATE USER
+	1253: ErrorTerminal, // collation isn't valid for the character set
+	1273: ErrorTerminal, // unknown collation
+	1410: ErrorTerminal, // not allowed to create a user with GRANT
+	1470: ErrorTerminal, // username or host name too long
+	1524: ErrorTerminal, // authentication plugin not loaded
+	1819: ErrorTerminal, // password doesn't satisfy the policy
+	3530: ErrorTerminal, // role or user doesn't exist for the privilege
+	3638: ErrorTerminal, // password was used before, under the password history policy
+
+	1007: ErrorConflict, // database already exists
+	1008: ErrorConflict, // database doesn't exist
+	1141: ErrorConflict, // no such grant for the user
+	1147: ErrorConflict, // no such table grant for the user
+	1396: ErrorConflict, // operation failed for user, as it exists or doesn't
+	1403: ErrorConflict, // no such grant on the routine
+}
+
+// IsPasswordPolicyError reports whether the server refused a password that doesn't satisfy
+// its validate_password settings
+func IsPasswordPolicyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1819
+}
+
+// ClassifyError decides how err is retried. Errors that don't come from the server, and
+// server errors we don't know, are taken to be transient.
+func ClassifyError(err error) ErrorClass {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		if class, ok := errorClasses[mysqlErr.Number]; ok {
+			return class
+		}
+
+	}
+
+	// lost connections, timeouts and anything else that isn't the server's answer
+	return ErrorTransient
+}
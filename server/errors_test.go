@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, ErrorTransient, ClassifyError(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}))
+	assert.Equal(t, ErrorTransient, ClassifyError(mysql.ErrInvalidConn))
+	assert.Equal(t, ErrorTransient, ClassifyError(errors.New("dial tcp: connection refused")))
+	assert.Equal(t, ErrorTransient, ClassifyError(&mysql.MySQLError{Number: 9999}))
+
+	assert.Equal(t, ErrorTerminal, ClassifyError(&mysql.MySQLError{Number: 1044, Message: "Access denied"}))
+	assert.Equal(t, ErrorTerminal, ClassifyError(&mysql.MySQLError{Number: 1115, Message: "Unknown character set"}))
+	assert.Equal(t, ErrorTerminal, ClassifyError(&mysql.MySQLError{Number: 3638, Message: "Cannot use these credentials"}))
+
+	assert.Equal(t, ErrorConflict, ClassifyError(&mysql.MySQLError{Number: 1396, Message: "Operation CREATE USER failed"}))
+
+	// wrapped errors are classified by the server error inside
+	wrapped := fmt.Errorf("creating account: %w", &mysql.MySQLError{Number: 1064})
+	assert.Equal(t, ErrorTerminal, ClassifyError(wrapped))
+}
+
+func TestIsPasswordPolicyError(t *testing.T) {
+	assert.True(t, IsPasswordPolicyError(fmt.Errorf("creating account: %w", &mysql.MySQLError{Number: 1819})))
+	assert.False(t, IsPasswordPolicyError(&mysql.MySQLError{Number: 1396}))
+	assert.False(t, IsPasswordPolicyError(nil))
+}
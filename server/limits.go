@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter caps the statements that change something on a server: how many run at once,
+// and how many start each second
+type Limiter struct {
+	slots chan struct{}
+	rate  *rate.Limiter
+}
+
+// NewLimiter allows concurrent statements at once and perSecond statements a second.
+// Either is unlimited if it's 0.
+func NewLimiter(concurrent int, perSecond float64) *Limiter {
+	l := &Limiter{}
+
+	if concurrent > 0 {
+		l.slots = make(chan struct{}, concurrent)
+	}
+
+	if perSecond > 0 {
+		l.rate = rate.NewLimiter(rate.Limit(perSecond), int(math.Ceil(perSecond)))
+	}
+
+	return l
+}
+
+// Acquire waits until a statement may run. The returned func must be called once it finished.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if l.rate != nil {
+		if err := l.rate.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Locks serializes work on the same objects on a server, such as a schema or an account,
+// even when it's done for different resources. The zero value is ready to use.
+type Locks struct {
+	mu   sync.Mutex
+	held map[string]*keyLock
+}
+
+type keyLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// Lock waits until it holds all the keys. The returned func releases them.
+func (l *Locks) Lock(ctx context.Context, keys ...string) (func(), error) {
+	// always taking keys in the same order keeps two callers from holding one each
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	var unlocks []func()
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+
+		unlock, err := l.lock(ctx, key)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+
+	return unlockAll, nil
+}
+
+func (l *Locks) lock(ctx context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	if l.held == nil {
+		l.held = map[string]*keyLock{}
+	}
+
+	kl, ok := l.held[key]
+	if !ok {
+		kl = &keyLock{ch: make(chan struct{}, 1)}
+		l.held[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		kl.refs--
+		if kl.refs == 0 {
+			delete(l.held, key)
+		}
+	}
+
+	select {
+	case kl.ch <- struct{}{}:
+		return func() {
+			<-kl.ch
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterConcurrency(t *testing.T) {
+	limiter := NewLimiter(2, 0)
+
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			release, err := limiter.Acquire(context.Background())
+			assert.NoError(t, err)
+			defer release()
+
+			now := atomic.AddInt32(&running, 1)
+			for {
+				seen := atomic.LoadInt32(&most)
+				if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), most)
+}
+
+func TestLimiterWaitsForSlot(t *testing.T) {
+	limiter := NewLimiter(1, 0)
+
+	release, err := limiter.Acquire(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = limiter.Acquire(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	release()
+	release, err = limiter.Acquire(context.Background())
+	assert.NoError(t, err)
+	release()
+}
+
+func TestLocks(t *testing.T) {
+	locks := &Locks{}
+
+	unlock, err := locks.Lock(context.Background(), "account/app", "schema/app")
+	assert.NoError(t, err)
+
+	// a shared key waits
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = locks.Lock(ctx, "schema/app")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// other keys don't, and repeated keys are taken once
+	other, err := locks.Lock(context.Background(), "schema/shop", "schema/shop")
+	assert.NoError(t, err)
+	other()
+
+	unlock()
+	unlock, err = locks.Lock(context.Background(), "schema/app")
+	assert.NoError(t, err)
+	unlock()
+
+	assert.Empty(t, locks.held)
+}
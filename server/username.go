@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// usernameHashLength is how many hex characters of the hash are kept in shortened usernames
+const usernameHashLength = 8
+
+// MaxUsernameLength returns the longest username the server accepts
+func (c *Capabilities) MaxUsernameLength() int {
+	if c.Flavor == FlavorMariaDB {
+		return 80
+	}
+
+	if c.Version.AtLeast(Version{5, 7, 8}) {
+		return 32
+	}
+
+	return 16
+}
+
+// DeriveUsername builds a username from a namespace and name, as `namespace_name` with
+// anything but letters and digits replaced by underscores. Namespaces and names can't hold
+// underscores, so the separator is unambiguous unless something was replaced: `a-b` in `c`
+// and `a` in `b-c` would both read `a_b_c`. Those usernames, and ones longer than maxLength,
+// end in a short hash of the namespace and name instead, truncated to fit, so they stay
+// deterministic and distinct.
+func DeriveUsername(namespace, name string, maxLength int) string {
+	replaced := false
+	sanitize := func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			replaced = true
+			return '_'
+		}
+	}
+	username := strings.Map(sanitize, namespace) + "_" + strings.Map(sanitize, name)
+
+	if !replaced && len(username) <= maxLength {
+		return username
+	}
+
+	sum := sha256.Sum256([]byte(namespace + "/" + name))
+	suffix := hex.EncodeToString(sum[:])[:usernameHashLength]
+
+	if length := maxLength - usernameHashLength - 1; len(username) > length {
+		username = username[:length]
+	}
+
+	return strings.TrimRight(username, "_") + "_" + suffix
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveUsername(t *testing.T) {
+	assert.Equal(t, "payments_exampledb", DeriveUsername("payments", "exampledb", 32))
+	assert.Regexp(t, `^payments_example_db_[0-9a-f]{8}$`, DeriveUsername("payments", "example-db", 32))
+	assert.Regexp(t, `^payments_example_db_v2_[0-9a-f]{8}$`, DeriveUsername("payments", "example.db.v2", 32))
+
+	// the same once characters are replaced, told apart by the hash
+	assert.NotEqual(t, DeriveUsername("a-b", "c", 32), DeriveUsername("a", "b-c", 32))
+
+	long := DeriveUsername("payments-production", "reporting-readonly-replica", 32)
+	assert.Len(t, long, 32)
+	assert.Equal(t, long, DeriveUsername("payments-production", "reporting-readonly-replica", 32))
+	assert.Regexp(t, `^payments_production_rep_[0-9a-f]{8}$`, long)
+
+	other := DeriveUsername("payments-production", "reporting-readonly-replica-2", 32)
+	assert.Len(t, other, 32)
+	assert.NotEqual(t, long, other)
+}
+
+func TestMaxUsernameLength(t *testing.T) {
+	for version, expected := range map[string]int{
+		"5.6.51":          16,
+		"5.7.42":          32,
+		"8.0.32":          32,
+		"10.6.12-MariaDB": 80,
+	} {
+		caps, err := ParseVersion(version)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, caps.MaxUsernameLength(), version)
+	}
+}